@@ -0,0 +1,461 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"gopkg.in/restruct.v1"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const AFCServiceName = "com.apple.afc"
+
+// "CFA6LPAA" read as a little endian integer
+const AFCMagic = 0x4141504c36414643
+
+const AFCHeaderSize = 40
+
+// Largest AFC packet accepted from a device
+const AFCMaxPacketSize = 64 * 1024 * 1024
+
+// Chunk sizes libimobiledevice uses for file transfers
+const (
+	AFCMaxReadSize  = 1 << 16
+	AFCMaxWriteSize = 1 << 15
+)
+
+const (
+	AFCOperationStatus               = 0x01
+	AFCOperationData                 = 0x02
+	AFCOperationReadDirectory        = 0x03
+	AFCOperationRemovePath           = 0x08
+	AFCOperationMakeDirectory        = 0x09
+	AFCOperationGetFileInfo          = 0x0a
+	AFCOperationGetDeviceInfo        = 0x0b
+	AFCOperationFileOpen             = 0x0d
+	AFCOperationFileOpenResult       = 0x0e
+	AFCOperationFileRead             = 0x0f
+	AFCOperationFileWrite            = 0x10
+	AFCOperationFileSeek             = 0x11
+	AFCOperationFileTell             = 0x12
+	AFCOperationFileTellResult       = 0x13
+	AFCOperationFileClose            = 0x14
+	AFCOperationRenamePath           = 0x18
+	AFCOperationRemovePathAndContent = 0x22
+)
+
+// File open modes
+const (
+	AFCModeReadOnly   = 1
+	AFCModeReadWrite  = 2
+	AFCModeWriteOnly  = 3 // create and truncate
+	AFCModeWriteRead  = 4 // create and truncate
+	AFCModeAppend     = 5
+	AFCModeReadAppend = 6
+)
+
+const (
+	AFCStatusSuccess          = 0
+	AFCStatusObjectNotFound   = 8
+	AFCStatusPermissionDenied = 10
+)
+
+var afcStatusNames = map[uint64]string{
+	1:  "unknown error",
+	2:  "operation header invalid",
+	3:  "no resources",
+	4:  "read error",
+	5:  "write error",
+	6:  "unknown packet type",
+	7:  "invalid argument",
+	8:  "object not found",
+	9:  "object is a directory",
+	10: "permission denied",
+	11: "service not connected",
+	12: "operation timeout",
+	13: "too much data",
+	14: "end of data",
+	15: "operation not supported",
+	16: "object exists",
+	17: "object busy",
+	18: "no space left",
+	19: "operation would block",
+	20: "io error",
+	21: "operation interrupted",
+	22: "operation in progress",
+	23: "internal error",
+	30: "mux error",
+	31: "no memory",
+	32: "not enough data",
+	33: "directory not empty",
+}
+
+// AFCError is a non zero status returned by the device.
+type AFCError struct {
+	Operation uint64
+	Status    uint64
+}
+
+func (err *AFCError) Error() string {
+	name, ok := afcStatusNames[err.Status]
+	if !ok {
+		name = fmt.Sprintf("status %d", err.Status)
+	}
+	return fmt.Sprintf("afc operation 0x%02x failed: %s", err.Operation, name)
+}
+
+type AFCHeader struct {
+	Magic        uint64
+	EntireLength uint64
+	ThisLength   uint64
+	PacketNumber uint64
+	Operation    uint64
+}
+
+type AFCPacket struct {
+	Header     AFCHeader
+	HeaderData []byte
+	Payload    []byte
+}
+
+// AFCFileInfo is the result of Stat.
+type AFCFileInfo struct {
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Type       string    `json:"type"`
+	Links      int64     `json:"links"`
+	Modified   time.Time `json:"modified"`
+	Created    time.Time `json:"created"`
+	LinkTarget string    `json:"linkTarget,omitempty"`
+}
+
+func (info *AFCFileInfo) IsDir() bool {
+	return info.Type == "S_IFDIR"
+}
+
+// AFCClient speaks the Apple File Conduit protocol over a device stream.
+type AFCClient struct {
+	conn io.ReadWriteCloser
+
+	// AFC is strictly request/response, one packet in flight at a time
+	mutex        sync.Mutex
+	packetNumber uint64
+}
+
+// AFCFile is an open file on the device.
+type AFCFile struct {
+	client *AFCClient
+	handle uint64
+}
+
+func newAFCClient(conn io.ReadWriteCloser) *AFCClient {
+	return &AFCClient{conn: conn}
+}
+
+// OpenAFC starts the media partition AFC service.
+func (device *RemoteDevice) OpenAFC(ctx context.Context) (*AFCClient, error) {
+	return device.openAFCService(ctx, AFCServiceName)
+}
+
+func (device *RemoteDevice) openAFCService(ctx context.Context, name string) (*AFCClient, error) {
+	conn, _, err := device.OpenServiceStream(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAFCClient(conn), nil
+}
+
+func (client *AFCClient) Close() error {
+	return client.conn.Close()
+}
+
+// request sends one packet and reads the answer, converting error statuses.
+func (client *AFCClient) request(operation uint64, headerData []byte, payload []byte) (*AFCPacket, error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+
+	header := &AFCHeader{
+		Magic:        AFCMagic,
+		EntireLength: uint64(AFCHeaderSize + len(headerData) + len(payload)),
+		ThisLength:   uint64(AFCHeaderSize + len(headerData)),
+		PacketNumber: client.packetNumber,
+		Operation:    operation,
+	}
+	client.packetNumber++
+
+	headerBytes, err := restruct.Pack(binary.LittleEndian, header)
+	if err != nil {
+		return nil, err
+	}
+
+	packet := make([]byte, 0, header.EntireLength)
+	packet = append(packet, headerBytes...)
+	packet = append(packet, headerData...)
+	packet = append(packet, payload...)
+	if _, err = client.conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	response, err := client.readPacket()
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Header.Operation == AFCOperationStatus {
+		status := uint64(0)
+		if len(response.HeaderData) >= 8 {
+			status = binary.LittleEndian.Uint64(response.HeaderData)
+		}
+		if status != AFCStatusSuccess {
+			return nil, &AFCError{Operation: operation, Status: status}
+		}
+	}
+
+	return response, nil
+}
+
+func (client *AFCClient) readPacket() (*AFCPacket, error) {
+	headerBytes := make([]byte, AFCHeaderSize)
+	if _, err := io.ReadFull(client.conn, headerBytes); err != nil {
+		return nil, err
+	}
+
+	packet := &AFCPacket{}
+	if err := restruct.Unpack(headerBytes, binary.LittleEndian, &packet.Header); err != nil {
+		return nil, err
+	}
+
+	header := packet.Header
+	if header.Magic != AFCMagic {
+		return nil, fmt.Errorf("afc bad magic %x", header.Magic)
+	}
+	if header.ThisLength < AFCHeaderSize || header.EntireLength < header.ThisLength || header.EntireLength > AFCMaxPacketSize {
+		return nil, fmt.Errorf("afc bad packet lengths %d/%d", header.ThisLength, header.EntireLength)
+	}
+
+	body := make([]byte, header.EntireLength-AFCHeaderSize)
+	if _, err := io.ReadFull(client.conn, body); err != nil {
+		return nil, err
+	}
+
+	packet.HeaderData = body[:header.ThisLength-AFCHeaderSize]
+	packet.Payload = body[header.ThisLength-AFCHeaderSize:]
+
+	return packet, nil
+}
+
+// afcStrings splits a payload of NUL terminated strings.
+func afcStrings(payload []byte) []string {
+	parts := strings.Split(string(payload), "\x00")
+	if len(parts) > 0 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	return parts
+}
+
+// afcDictionary decodes a payload of alternating NUL terminated keys and values.
+func afcDictionary(payload []byte) map[string]string {
+	parts := afcStrings(payload)
+	dictionary := make(map[string]string, len(parts)/2)
+	for index := 0; index+1 < len(parts); index += 2 {
+		dictionary[parts[index]] = parts[index+1]
+	}
+	return dictionary
+}
+
+func afcPath(path string) []byte {
+	return append([]byte(path), 0)
+}
+
+func afcUint64(values ...uint64) []byte {
+	data := make([]byte, 8*len(values))
+	for index, value := range values {
+		binary.LittleEndian.PutUint64(data[8*index:], value)
+	}
+	return data
+}
+
+// ReadDirectory lists the names in a directory, without "." and "..".
+func (client *AFCClient) ReadDirectory(path string) ([]string, error) {
+	response, err := client.request(AFCOperationReadDirectory, afcPath(path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range afcStrings(response.Payload) {
+		if name != "." && name != ".." {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func (client *AFCClient) Stat(path string) (*AFCFileInfo, error) {
+	response, err := client.request(AFCOperationGetFileInfo, afcPath(path), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	values := afcDictionary(response.Payload)
+	parseInt := func(key string) int64 {
+		value, _ := strconv.ParseInt(values[key], 10, 64)
+		return value
+	}
+
+	name := path
+	if index := strings.LastIndex(strings.TrimRight(path, "/"), "/"); index >= 0 {
+		name = strings.TrimRight(path, "/")[index+1:]
+	}
+
+	return &AFCFileInfo{
+		Name:       name,
+		Size:       parseInt("st_size"),
+		Type:       values["st_ifmt"],
+		Links:      parseInt("st_nlink"),
+		Modified:   time.Unix(0, parseInt("st_mtime")),
+		Created:    time.Unix(0, parseInt("st_birthtime")),
+		LinkTarget: values["LinkTarget"],
+	}, nil
+}
+
+// DeviceInfo returns file system information such as Model, FSTotalBytes and FSFreeBytes.
+func (client *AFCClient) DeviceInfo() (map[string]string, error) {
+	response, err := client.request(AFCOperationGetDeviceInfo, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return afcDictionary(response.Payload), nil
+}
+
+func (client *AFCClient) MakeDirectory(path string) error {
+	_, err := client.request(AFCOperationMakeDirectory, afcPath(path), nil)
+	return err
+}
+
+// Remove deletes a file or an empty directory.
+func (client *AFCClient) Remove(path string) error {
+	_, err := client.request(AFCOperationRemovePath, afcPath(path), nil)
+	return err
+}
+
+// RemoveAll deletes path and everything below it.
+func (client *AFCClient) RemoveAll(path string) error {
+	_, err := client.request(AFCOperationRemovePathAndContent, afcPath(path), nil)
+	return err
+}
+
+func (client *AFCClient) Rename(from string, to string) error {
+	_, err := client.request(AFCOperationRenamePath, append(afcPath(from), afcPath(to)...), nil)
+	return err
+}
+
+// Open opens path with one of the AFCMode constants.
+func (client *AFCClient) Open(path string, mode uint64) (*AFCFile, error) {
+	response, err := client.request(AFCOperationFileOpen, append(afcUint64(mode), afcPath(path)...), nil)
+	if err != nil {
+		return nil, err
+	}
+	if response.Header.Operation != AFCOperationFileOpenResult || len(response.HeaderData) < 8 {
+		return nil, fmt.Errorf("afc unexpected open response 0x%02x", response.Header.Operation)
+	}
+
+	return &AFCFile{client: client, handle: binary.LittleEndian.Uint64(response.HeaderData)}, nil
+}
+
+func (file *AFCFile) Read(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+
+	size := len(data)
+	if size > AFCMaxReadSize {
+		size = AFCMaxReadSize
+	}
+
+	response, err := file.client.request(AFCOperationFileRead, afcUint64(file.handle, uint64(size)), nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(response.Payload) == 0 {
+		return 0, io.EOF
+	}
+
+	return copy(data, response.Payload), nil
+}
+
+func (file *AFCFile) Write(data []byte) (int, error) {
+	written := 0
+	for written < len(data) {
+		chunk := data[written:]
+		if len(chunk) > AFCMaxWriteSize {
+			chunk = chunk[:AFCMaxWriteSize]
+		}
+
+		if _, err := file.client.request(AFCOperationFileWrite, afcUint64(file.handle), chunk); err != nil {
+			return written, err
+		}
+		written += len(chunk)
+	}
+	return written, nil
+}
+
+// Seek moves the file position, whence being one of the io.Seek constants.
+func (file *AFCFile) Seek(offset int64, whence int) (int64, error) {
+	if _, err := file.client.request(AFCOperationFileSeek, afcUint64(file.handle, uint64(whence), uint64(offset)), nil); err != nil {
+		return 0, err
+	}
+
+	response, err := file.client.request(AFCOperationFileTell, afcUint64(file.handle), nil)
+	if err != nil {
+		return 0, err
+	}
+	if len(response.HeaderData) < 8 {
+		return 0, fmt.Errorf("afc unexpected tell response 0x%02x", response.Header.Operation)
+	}
+
+	return int64(binary.LittleEndian.Uint64(response.HeaderData)), nil
+}
+
+func (file *AFCFile) Close() error {
+	_, err := file.client.request(AFCOperationFileClose, afcUint64(file.handle), nil)
+	return err
+}
+
+// WriteFile streams reader into path, replacing it.
+func (client *AFCClient) WriteFile(path string, reader io.Reader) (int64, error) {
+	file, err := client.Open(path, AFCModeWriteOnly)
+	if err != nil {
+		return 0, err
+	}
+
+	// Keep writes at AFCMaxWriteSize so every chunk goes out as one packet
+	written, err := io.CopyBuffer(file, reader, make([]byte, AFCMaxWriteSize))
+	closeErr := file.Close()
+	if err == nil {
+		err = closeErr
+	}
+	return written, err
+}
+
+// ReadFile reads a whole, small, file into memory.
+func (client *AFCClient) ReadFile(path string) ([]byte, error) {
+	file, err := client.Open(path, AFCModeReadOnly)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var buffer bytes.Buffer
+	if _, err = io.CopyBuffer(&buffer, file, make([]byte, AFCMaxReadSize)); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
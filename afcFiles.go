@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+)
+
+// handleFiles serves the media partition under /v1/devices/{id}/files/{path}.
+func handleFiles(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, filePath string) {
+	client, err := device.OpenAFC(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	serveAFC(client, writer, request, filePath)
+}
+
+// serveAFC lists directories and downloads files on GET and uploads files on
+// PUT. File contents are streamed in AFC sized chunks in both directions, and
+// directory listings as one JSON line per entry.
+func serveAFC(client *AFCClient, writer http.ResponseWriter, request *http.Request, filePath string) {
+	filePath = path.Clean("/" + filePath)
+
+	switch request.Method {
+	case http.MethodGet:
+		info, err := client.Stat(filePath)
+		if err != nil {
+			writeError(writer, afcErrorStatus(err), err)
+			return
+		}
+
+		if info.IsDir() {
+			serveAFCDirectory(client, writer, filePath)
+			return
+		}
+
+		file, err := client.Open(filePath, AFCModeReadOnly)
+		if err != nil {
+			writeError(writer, afcErrorStatus(err), err)
+			return
+		}
+		defer file.Close()
+
+		writer.Header().Set("Content-Type", "application/octet-stream")
+		writer.Header().Set("Content-Length", strconv.FormatInt(info.Size, 10))
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name))
+		writer.WriteHeader(http.StatusOK)
+
+		if _, err = io.CopyBuffer(writer, file, make([]byte, AFCMaxReadSize)); err != nil {
+			fmt.Printf("AFC download of %s failed: %s\n", filePath, err)
+		}
+
+	case http.MethodPut:
+		written, err := client.WriteFile(filePath, request.Body)
+		if err != nil {
+			writeError(writer, afcErrorStatus(err), err)
+			return
+		}
+
+		writeJSON(writer, http.StatusCreated, map[string]interface{}{"path": filePath, "size": written})
+
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", request.Method))
+	}
+}
+
+// serveAFCDirectory writes each entry as soon as it was stat'ed, so a large
+// directory starts arriving right away instead of after a round trip per entry.
+func serveAFCDirectory(client *AFCClient, writer http.ResponseWriter, directory string) {
+	names, err := client.ReadDirectory(directory)
+	if err != nil {
+		writeError(writer, afcErrorStatus(err), err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+	encoder := json.NewEncoder(writer)
+
+	for _, name := range names {
+		info, err := client.Stat(path.Join(directory, name))
+		if err != nil {
+			// Entries can vanish while listing
+			continue
+		}
+
+		if err = encoder.Encode(info); err != nil {
+			fmt.Printf("AFC listing of %s aborted: %s\n", directory, err)
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+}
+
+func afcErrorStatus(err error) int {
+	var afcErr *AFCError
+	if errors.As(err, &afcErr) {
+		switch afcErr.Status {
+		case AFCStatusObjectNotFound:
+			return http.StatusNotFound
+		case AFCStatusPermissionDenied:
+			return http.StatusForbidden
+		}
+	}
+	return http.StatusBadGateway
+}
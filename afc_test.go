@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testAFCRequest is a packet as the device receives it, decoded by hand.
+type testAFCRequest struct {
+	raw          []byte
+	packetNumber uint64
+	operation    uint64
+	headerData   []byte
+	payload      []byte
+}
+
+// testAFCDevice plays an AFC service, answering each request with reply.
+type testAFCDevice struct {
+	t        *testing.T
+	conn     net.Conn
+	requests chan *testAFCRequest
+	reply    func(request *testAFCRequest) (uint64, []byte, []byte)
+}
+
+func newTestAFC(t *testing.T, reply func(request *testAFCRequest) (uint64, []byte, []byte)) (*AFCClient, *testAFCDevice) {
+	host, conn := net.Pipe()
+	t.Cleanup(func() {
+		host.Close()
+		conn.Close()
+	})
+
+	device := &testAFCDevice{t: t, conn: conn, requests: make(chan *testAFCRequest, 64), reply: reply}
+	go device.serve()
+
+	return newAFCClient(host), device
+}
+
+func (device *testAFCDevice) serve() {
+	for {
+		header := make([]byte, AFCHeaderSize)
+		if _, err := io.ReadFull(device.conn, header); err != nil {
+			return
+		}
+
+		entireLength := binary.LittleEndian.Uint64(header[8:])
+		thisLength := binary.LittleEndian.Uint64(header[16:])
+		body := make([]byte, entireLength-AFCHeaderSize)
+		if _, err := io.ReadFull(device.conn, body); err != nil {
+			return
+		}
+
+		request := &testAFCRequest{
+			raw:          append(header, body...),
+			packetNumber: binary.LittleEndian.Uint64(header[24:]),
+			operation:    binary.LittleEndian.Uint64(header[32:]),
+			headerData:   body[:thisLength-AFCHeaderSize],
+			payload:      body[thisLength-AFCHeaderSize:],
+		}
+		device.requests <- request
+
+		operation, headerData, payload := device.reply(request)
+		if _, err := device.conn.Write(testAFCPacket(operation, request.packetNumber, headerData, payload)); err != nil {
+			return
+		}
+	}
+}
+
+// next returns the next request the device received.
+func (device *testAFCDevice) next() *testAFCRequest {
+	select {
+	case request := <-device.requests:
+		return request
+	case <-time.After(5 * time.Second):
+		device.t.Fatalf("no AFC request received")
+		return nil
+	}
+}
+
+func testAFCPacket(operation uint64, packetNumber uint64, headerData []byte, payload []byte) []byte {
+	packet := []byte("CFA6LPAA")
+	packet = append(packet, afcUint64(
+		uint64(AFCHeaderSize+len(headerData)+len(payload)),
+		uint64(AFCHeaderSize+len(headerData)),
+		packetNumber,
+		operation,
+	)...)
+	packet = append(packet, headerData...)
+	return append(packet, payload...)
+}
+
+func afcStatus(status uint64) (uint64, []byte, []byte) {
+	return AFCOperationStatus, afcUint64(status), nil
+}
+
+func TestAFCPacketFraming(t *testing.T) {
+	client, device := newTestAFC(t, func(request *testAFCRequest) (uint64, []byte, []byte) {
+		return AFCOperationData, afcUint64(7), []byte("reply")
+	})
+
+	response, err := client.request(AFCOperationFileWrite, afcUint64(3), []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := device.next()
+	expected := testAFCPacket(AFCOperationFileWrite, 0, afcUint64(3), []byte("data"))
+	if !bytes.Equal(request.raw, expected) {
+		t.Fatalf("expected packet %x, sent %x", expected, request.raw)
+	}
+	if !bytes.Equal(request.raw[:8], []byte("CFA6LPAA")) {
+		t.Fatalf("expected the magic first, got %q", request.raw[:8])
+	}
+
+	// The this-length of the response separates header data from payload
+	if !bytes.Equal(response.HeaderData, afcUint64(7)) || string(response.Payload) != "reply" {
+		t.Fatalf("response split as %x / %q", response.HeaderData, response.Payload)
+	}
+
+	if _, err = client.request(AFCOperationGetDeviceInfo, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if request = device.next(); request.packetNumber != 1 || len(request.raw) != AFCHeaderSize {
+		t.Fatalf("expected an empty second packet numbered 1, got number %d of %d bytes", request.packetNumber, len(request.raw))
+	}
+}
+
+func TestAFCStatusError(t *testing.T) {
+	client, _ := newTestAFC(t, func(request *testAFCRequest) (uint64, []byte, []byte) {
+		return afcStatus(AFCStatusObjectNotFound)
+	})
+
+	err := client.MakeDirectory("/missing/directory")
+	var afcErr *AFCError
+	if !errors.As(err, &afcErr) || afcErr.Status != AFCStatusObjectNotFound || afcErr.Operation != AFCOperationMakeDirectory {
+		t.Fatalf("expected object not found for the make directory operation, got %v", err)
+	}
+}
+
+func TestAFCRejectsBadPackets(t *testing.T) {
+	packets := map[string][]byte{
+		"magic":              append([]byte("CFA6LPAB"), testAFCPacket(AFCOperationData, 0, nil, nil)[8:]...),
+		"short this-length":  append(testAFCPacket(AFCOperationData, 0, nil, nil)[:16], afcUint64(AFCHeaderSize-1, 0, AFCOperationData)...),
+		"entire before this": append(append(testAFCPacket(AFCOperationData, 0, nil, nil)[:8], afcUint64(AFCHeaderSize, AFCHeaderSize+8, 0, AFCOperationData)...), afcUint64(0)...),
+	}
+
+	for name, packet := range packets {
+		host, device := net.Pipe()
+		go device.Write(packet)
+
+		if _, err := newAFCClient(host).readPacket(); err == nil {
+			t.Errorf("%s: expected the packet to be rejected", name)
+		}
+		host.Close()
+		device.Close()
+	}
+}
+
+func TestAFCFileOperations(t *testing.T) {
+	const handle = 42
+	client, device := newTestAFC(t, func(request *testAFCRequest) (uint64, []byte, []byte) {
+		switch request.operation {
+		case AFCOperationFileOpen:
+			return AFCOperationFileOpenResult, afcUint64(handle), nil
+		case AFCOperationFileRead:
+			return AFCOperationData, nil, []byte("contents")
+		case AFCOperationFileTell:
+			return AFCOperationFileTellResult, afcUint64(1234), nil
+		}
+		return afcStatus(AFCStatusSuccess)
+	})
+
+	// Open: mode then the NUL terminated path
+	file, err := client.Open("/DCIM/file", AFCModeReadWrite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := device.next()
+	if expected := append(afcUint64(AFCModeReadWrite), "/DCIM/file\x00"...); !bytes.Equal(request.headerData, expected) {
+		t.Fatalf("open header data %x, expected %x", request.headerData, expected)
+	}
+	if file.handle != handle {
+		t.Fatalf("expected handle %d, got %d", handle, file.handle)
+	}
+
+	// Read: handle and a length capped at AFCMaxReadSize
+	data := make([]byte, 2*AFCMaxReadSize)
+	count, err := file.Read(data)
+	if err != nil || string(data[:count]) != "contents" {
+		t.Fatalf("read %q (%v)", data[:count], err)
+	}
+	if request = device.next(); !bytes.Equal(request.headerData, afcUint64(handle, AFCMaxReadSize)) {
+		t.Fatalf("read header data %x", request.headerData)
+	}
+
+	// Write: handle in the header data, the file data as payload in chunks
+	written := bytes.Repeat([]byte{'w'}, AFCMaxWriteSize+10)
+	if count, err = file.Write(written); err != nil || count != len(written) {
+		t.Fatalf("wrote %d (%v)", count, err)
+	}
+	for _, size := range []int{AFCMaxWriteSize, 10} {
+		request = device.next()
+		if request.operation != AFCOperationFileWrite || !bytes.Equal(request.headerData, afcUint64(handle)) || len(request.payload) != size {
+			t.Fatalf("write 0x%02x with header data %x and %d bytes of payload, expected %d", request.operation, request.headerData, len(request.payload), size)
+		}
+	}
+
+	// Seek: handle, whence, offset, followed by a tell for the new position
+	position, err := file.Seek(-4, io.SeekEnd)
+	if err != nil || position != 1234 {
+		t.Fatalf("seek returned %d (%v)", position, err)
+	}
+	offset := int64(-4)
+	if request = device.next(); !bytes.Equal(request.headerData, afcUint64(handle, io.SeekEnd, uint64(offset))) {
+		t.Fatalf("seek header data %x", request.headerData)
+	}
+	if request = device.next(); request.operation != AFCOperationFileTell || !bytes.Equal(request.headerData, afcUint64(handle)) {
+		t.Fatalf("tell 0x%02x with header data %x", request.operation, request.headerData)
+	}
+
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if request = device.next(); request.operation != AFCOperationFileClose || !bytes.Equal(request.headerData, afcUint64(handle)) {
+		t.Fatalf("close 0x%02x with header data %x", request.operation, request.headerData)
+	}
+}
+
+func TestAFCStat(t *testing.T) {
+	client, device := newTestAFC(t, func(request *testAFCRequest) (uint64, []byte, []byte) {
+		return AFCOperationData, nil, []byte("st_size\x004096\x00st_ifmt\x00S_IFDIR\x00st_nlink\x003\x00st_mtime\x001700000000000000000\x00st_birthtime\x001600000000000000000\x00")
+	})
+
+	info, err := client.Stat("/DCIM/100APPLE/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if request := device.next(); request.operation != AFCOperationGetFileInfo || string(request.headerData) != "/DCIM/100APPLE/\x00" {
+		t.Fatalf("stat 0x%02x with header data %q", request.operation, request.headerData)
+	}
+
+	if info.Name != "100APPLE" || info.Size != 4096 || !info.IsDir() || info.Links != 3 {
+		t.Fatalf("unexpected info %+v", info)
+	}
+	if !info.Modified.Equal(time.Unix(1700000000, 0)) || !info.Created.Equal(time.Unix(1600000000, 0)) {
+		t.Fatalf("unexpected times %s, %s", info.Modified, info.Created)
+	}
+}
@@ -0,0 +1,24 @@
+package main
+
+import "time"
+
+// Clock is the time source behind channel timers, so timeouts can be driven by
+// a fake clock instead of waiting on the wall clock.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(duration time.Duration, function func()) ClockTimer
+}
+
+type ClockTimer interface {
+	Stop() bool
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (systemClock) AfterFunc(duration time.Duration, function func()) ClockTimer {
+	return time.AfterFunc(duration, function)
+}
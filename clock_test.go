@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// manualClock only moves when Advance is called, firing due timers on the
+// calling goroutine.
+type manualClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []*manualTimer
+}
+
+type manualTimer struct {
+	clock    *manualClock
+	when     time.Time
+	function func()
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{now: time.Unix(1000000, 0)}
+}
+
+func (clock *manualClock) Now() time.Time {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return clock.now
+}
+
+func (clock *manualClock) AfterFunc(duration time.Duration, function func()) ClockTimer {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	timer := &manualTimer{clock: clock, when: clock.now.Add(duration), function: function}
+	clock.timers = append(clock.timers, timer)
+	return timer
+}
+
+// Advance moves the clock forward by duration, running each timer that falls
+// due at its own point in time, including timers those functions start.
+func (clock *manualClock) Advance(duration time.Duration) {
+	clock.mutex.Lock()
+	target := clock.now.Add(duration)
+
+	for {
+		sort.SliceStable(clock.timers, func(i, j int) bool {
+			return clock.timers[i].when.Before(clock.timers[j].when)
+		})
+		if len(clock.timers) == 0 || clock.timers[0].when.After(target) {
+			break
+		}
+
+		timer := clock.timers[0]
+		clock.timers = clock.timers[1:]
+		clock.now = timer.when
+
+		clock.mutex.Unlock()
+		timer.function()
+		clock.mutex.Lock()
+	}
+
+	clock.now = target
+	clock.mutex.Unlock()
+}
+
+// pending returns the number of timers that have not fired or been stopped.
+func (clock *manualClock) pending() int {
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	return len(clock.timers)
+}
+
+func (timer *manualTimer) Stop() bool {
+	clock := timer.clock
+	clock.mutex.Lock()
+	defer clock.mutex.Unlock()
+
+	for index, pending := range clock.timers {
+		if pending == timer {
+			clock.timers = append(clock.timers[:index], clock.timers[index+1:]...)
+			return true
+		}
+	}
+	return false
+}
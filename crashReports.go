@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+const (
+	CrashReportMoverServiceName = "com.apple.crashreportmover"
+	CrashReportCopyServiceName  = "com.apple.crashreportcopymobile"
+)
+
+// How long crashreportmover may take to move the reports into place
+const CrashReportMoveTimeout = 30 * time.Second
+
+// CrashReport is a crash report saved on the host.
+type CrashReport struct {
+	Path     string    `json:"path"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// MoveCrashReports has crashreportmover move new reports to where
+// crashreportcopymobile serves them. It returns once the device pinged back.
+func (device *RemoteDevice) MoveCrashReports(ctx context.Context) error {
+	conn, _, err := device.OpenServiceStream(ctx, CrashReportMoverServiceName)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	ping := make([]byte, 4)
+	if _, err = io.ReadFull(conn, ping); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	if string(ping) != "ping" {
+		return fmt.Errorf("crashreportmover answered %q", ping)
+	}
+
+	return nil
+}
+
+// crashReportDirectory is where the device's reports are saved below the
+// -crash-reports directory.
+func (device *RemoteDevice) crashReportDirectory() string {
+	return filepath.Join(*crashReportsFlag, device.udid())
+}
+
+// PullCrashReports moves and copies the device's crash reports into its local
+// directory, skipping reports saved before, and deletes them from the device
+// when remove is set. It returns the newly saved reports.
+func (device *RemoteDevice) PullCrashReports(ctx context.Context, remove bool) ([]*CrashReport, error) {
+	moveCtx, cancel := context.WithTimeout(ctx, CrashReportMoveTimeout)
+	err := device.MoveCrashReports(moveCtx)
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := device.openAFCService(ctx, CrashReportCopyServiceName)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	pulled := []*CrashReport{}
+	err = pullCrashReports(ctx, client, "/", device.crashReportDirectory(), remove, &pulled)
+	return pulled, err
+}
+
+func pullCrashReports(ctx context.Context, client *AFCClient, directory string, localDirectory string, remove bool, pulled *[]*CrashReport) error {
+	names, err := client.ReadDirectory(directory)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if name == "." || name == ".." {
+			continue
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		devicePath := path.Join(directory, name)
+		info, err := client.Stat(devicePath)
+		if err != nil {
+			continue
+		}
+
+		localPath := filepath.Join(localDirectory, filepath.FromSlash(devicePath))
+		switch info.Type {
+		case "S_IFDIR":
+			if err = pullCrashReports(ctx, client, devicePath, localDirectory, remove, pulled); err != nil {
+				return err
+			}
+			continue
+		case "S_IFREG":
+		default:
+			continue
+		}
+
+		if local, err := os.Stat(localPath); err != nil || local.Size() != info.Size {
+			if err = pullCrashReport(client, devicePath, localPath, info.Modified); err != nil {
+				return err
+			}
+			*pulled = append(*pulled, &CrashReport{Path: devicePath, Size: info.Size, Modified: info.Modified})
+		}
+
+		if remove {
+			if err = client.Remove(devicePath); err != nil {
+				fmt.Printf("Crash report %s not removed from device: %s\n", devicePath, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func pullCrashReport(client *AFCClient, devicePath string, localPath string, modified time.Time) error {
+	file, err := client.Open(devicePath, AFCModeReadOnly)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return err
+	}
+
+	// Copy next to the target so an interrupted pull is retried next time
+	temporaryPath := localPath + ".tmp"
+	output, err := os.Create(temporaryPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.CopyBuffer(output, file, make([]byte, AFCMaxReadSize))
+	if closeErr := output.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temporaryPath)
+		return err
+	}
+
+	if err = os.Rename(temporaryPath, localPath); err != nil {
+		return err
+	}
+	os.Chtimes(localPath, modified, modified)
+
+	return nil
+}
+
+// savedCrashReports lists the reports saved for the device.
+func (device *RemoteDevice) savedCrashReports() ([]*CrashReport, error) {
+	root := device.crashReportDirectory()
+	reports := []*CrashReport{}
+
+	err := filepath.Walk(root, func(localPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && localPath == root {
+				return nil
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() || filepath.Ext(localPath) == ".tmp" {
+			return nil
+		}
+
+		relative, err := filepath.Rel(root, localPath)
+		if err != nil {
+			return err
+		}
+		reports = append(reports, &CrashReport{Path: "/" + filepath.ToSlash(relative), Size: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+
+	return reports, err
+}
+
+// handleCrashReports serves the crash reports saved below -crash-reports:
+//
+//	GET  /v1/devices/{id}/crashes
+//	GET  /v1/devices/{id}/crashes/{path}
+//	POST /v1/devices/{id}/crashes?delete=true
+func handleCrashReports(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, reportPath string) {
+	switch {
+	case request.Method == http.MethodPost && reportPath == "":
+		remove := request.URL.Query().Get("delete")
+		pulled, err := device.PullCrashReports(request.Context(), remove == "true" || remove == "1")
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, pulled)
+
+	case request.Method == http.MethodGet && reportPath == "":
+		reports, err := device.savedCrashReports()
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, reports)
+
+	case request.Method == http.MethodGet:
+		localPath := filepath.Join(device.crashReportDirectory(), filepath.FromSlash(path.Clean("/"+reportPath)))
+		file, err := os.Open(localPath)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if os.IsNotExist(err) {
+				status = http.StatusNotFound
+			}
+			writeError(writer, status, err)
+			return
+		}
+		defer file.Close()
+
+		info, err := file.Stat()
+		if err != nil || !info.Mode().IsRegular() {
+			writeError(writer, http.StatusNotFound, fmt.Errorf("no crash report %s", reportPath))
+			return
+		}
+
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", info.Name()))
+		http.ServeContent(writer, request, info.Name(), info.ModTime(), file)
+
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on crashes/%s", request.Method, reportPath))
+	}
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// Data a DeviceConn lets Write queue on its channel before blocking
+const DeviceConnSendBufferSize = 256 * 1024
+
+var (
+	ErrDeviceConnClosed  = errors.New("use of closed device connection")
+	ErrDeviceConnRefused = errors.New("device refused connection")
+	ErrDeviceConnReset   = errors.New("connection reset by device")
+	ErrDeviceNoPorts     = errors.New("no free source port on device")
+)
+
+// DeviceAddr is the address of one end of a device TCP channel.
+type DeviceAddr struct {
+	serialNumber string
+	port         uint16
+}
+
+func (addr *DeviceAddr) Network() string {
+	return "usbmux"
+}
+
+func (addr *DeviceAddr) String() string {
+	return fmt.Sprintf("%s:%d", addr.serialNumber, addr.port)
+}
+
+// DeviceConn is a net.Conn backed by a TCPChannel to a port on the device.
+type DeviceConn struct {
+	device  *RemoteDevice
+	channel *TCPChannel
+	port    uint16
+
+	mutex sync.Mutex
+
+	// Received data not read yet
+	buffer []byte
+
+	// Last state reported by the channel
+	state     int
+	connected bool
+
+	// Close was called locally
+	closed bool
+
+	// CloseWrite was called locally, reads carry on
+	writeClosed bool
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+
+	// Closed and replaced whenever anything a blocked call waits for changes
+	changed chan struct{}
+}
+
+// Dial opens a connection to port on the device. It returns once the device
+// accepted the connection, refused it, or ctx is done.
+//
+// Like a net.TCPConn the connection starts with no-delay set, see SetNoDelay.
+func (device *RemoteDevice) Dial(ctx context.Context, port uint16) (net.Conn, error) {
+	conn := &DeviceConn{
+		device:  device,
+		port:    port,
+		state:   TCPStateNew,
+		changed: make(chan struct{}),
+	}
+
+	channel := device.createTCPChannel(port, conn)
+	if channel == nil {
+		return nil, ErrDeviceNoPorts
+	}
+	channel.setNoDelay(true)
+
+	conn.mutex.Lock()
+	conn.channel = channel
+	for {
+		switch {
+		case conn.connected:
+			conn.mutex.Unlock()
+			return conn, nil
+		case conn.state == TCPStateRefused || conn.state == TCPStateClosed:
+			conn.mutex.Unlock()
+			return nil, ErrDeviceConnRefused
+		}
+
+		changed := conn.changed
+		conn.mutex.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			channel.Abort()
+			return nil, ctx.Err()
+		}
+
+		conn.mutex.Lock()
+	}
+}
+
+// adoptChannel wraps an established channel in a DeviceConn, which becomes the
+// channel's handler from now on. No-delay is set as for Dial.
+func (device *RemoteDevice) adoptChannel(channel *TCPChannel) *DeviceConn {
+	conn := &DeviceConn{
+		device:    device,
+		channel:   channel,
+		port:      channel.destinationPort,
+		state:     TCPStateConnected,
+		connected: true,
+		changed:   make(chan struct{}),
+	}
+
+	channel.setHandler(conn)
+	channel.setNoDelay(true)
+
+	return conn
+}
+
+// broadcast wakes every blocked call. The caller holds mutex.
+func (conn *DeviceConn) broadcast() {
+	close(conn.changed)
+	conn.changed = make(chan struct{})
+}
+
+// wait blocks until changed is closed or deadline passes, returning false on timeout.
+func (conn *DeviceConn) wait(changed chan struct{}, deadline time.Time) bool {
+	if deadline.IsZero() {
+		<-changed
+		return true
+	}
+
+	timer := time.NewTimer(time.Until(deadline))
+	defer timer.Stop()
+
+	select {
+	case <-changed:
+		return true
+	case <-timer.C:
+		return false
+	}
+}
+
+func (conn *DeviceConn) buffersReceivedData() {}
+
+func (conn *DeviceConn) receiveData(data []byte) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.buffer = append(conn.buffer, data...)
+	conn.broadcast()
+}
+
+func (conn *DeviceConn) connectionStateChange(state int) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.state = state
+	if state == TCPStateConnected {
+		conn.connected = true
+	}
+	conn.broadcast()
+}
+
+func (conn *DeviceConn) sendSpaceAvailable() {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.broadcast()
+}
+
+// peerFinished reports whether the device closed its side. The caller holds mutex.
+func (conn *DeviceConn) peerFinished() bool {
+	switch conn.state {
+	case TCPStateCloseWait, TCPStateClosing, TCPStateTimeWait, TCPStateClosed:
+		return true
+	}
+	return false
+}
+
+func (conn *DeviceConn) Read(data []byte) (int, error) {
+	conn.mutex.Lock()
+	for {
+		if conn.closed {
+			conn.mutex.Unlock()
+			return 0, ErrDeviceConnClosed
+		}
+
+		if len(conn.buffer) > 0 {
+			count := copy(data, conn.buffer)
+			conn.buffer = conn.buffer[count:]
+			conn.mutex.Unlock()
+
+			conn.channel.consume(count)
+			return count, nil
+		}
+
+		if conn.state == TCPStateRefused {
+			conn.mutex.Unlock()
+			return 0, ErrDeviceConnReset
+		}
+		if conn.peerFinished() {
+			conn.mutex.Unlock()
+			return 0, io.EOF
+		}
+
+		deadline := conn.readDeadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			conn.mutex.Unlock()
+			return 0, os.ErrDeadlineExceeded
+		}
+
+		changed := conn.changed
+		conn.mutex.Unlock()
+		conn.wait(changed, deadline)
+		conn.mutex.Lock()
+	}
+}
+
+func (conn *DeviceConn) Write(data []byte) (int, error) {
+	written := 0
+
+	conn.mutex.Lock()
+	for written < len(data) {
+		if conn.closed {
+			conn.mutex.Unlock()
+			return written, ErrDeviceConnClosed
+		}
+		if conn.state == TCPStateRefused {
+			conn.mutex.Unlock()
+			return written, ErrDeviceConnReset
+		}
+		if conn.writeClosed || (conn.state != TCPStateConnected && conn.state != TCPStateCloseWait) {
+			conn.mutex.Unlock()
+			return written, io.ErrClosedPipe
+		}
+
+		deadline := conn.writeDeadline
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			conn.mutex.Unlock()
+			return written, os.ErrDeadlineExceeded
+		}
+
+		room := DeviceConnSendBufferSize - conn.channel.queued()
+		if room > 0 {
+			if room > len(data)-written {
+				room = len(data) - written
+			}
+			conn.mutex.Unlock()
+
+			conn.channel.send(data[written : written+room])
+			written += room
+
+			conn.mutex.Lock()
+			continue
+		}
+
+		changed := conn.changed
+		conn.mutex.Unlock()
+		conn.wait(changed, deadline)
+		conn.mutex.Lock()
+	}
+	conn.mutex.Unlock()
+
+	return written, nil
+}
+
+// Close sends FIN once queued data went out. Blocked calls return ErrDeviceConnClosed.
+func (conn *DeviceConn) Close() error {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return ErrDeviceConnClosed
+	}
+	conn.closed = true
+	conn.buffer = nil
+	conn.broadcast()
+	conn.mutex.Unlock()
+
+	conn.channel.Close()
+	return nil
+}
+
+// CloseWrite sends FIN once queued data went out while still reading what the
+// device sends, like net.TCPConn.CloseWrite.
+func (conn *DeviceConn) CloseWrite() error {
+	conn.mutex.Lock()
+	if conn.closed {
+		conn.mutex.Unlock()
+		return ErrDeviceConnClosed
+	}
+	conn.writeClosed = true
+	conn.broadcast()
+	conn.mutex.Unlock()
+
+	conn.channel.Close()
+	return nil
+}
+
+// SetNoDelay controls whether short writes are sent right away (true) or held
+// back until earlier data was acknowledged so they can be coalesced.
+func (conn *DeviceConn) SetNoDelay(noDelay bool) error {
+	conn.channel.setNoDelay(noDelay)
+	return nil
+}
+
+func (conn *DeviceConn) LocalAddr() net.Addr {
+	return &DeviceAddr{serialNumber: conn.device.serialNumber, port: conn.channel.sourcePort}
+}
+
+func (conn *DeviceConn) RemoteAddr() net.Addr {
+	return &DeviceAddr{serialNumber: conn.device.serialNumber, port: conn.port}
+}
+
+func (conn *DeviceConn) SetDeadline(deadline time.Time) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readDeadline = deadline
+	conn.writeDeadline = deadline
+	conn.broadcast()
+	return nil
+}
+
+func (conn *DeviceConn) SetReadDeadline(deadline time.Time) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.readDeadline = deadline
+	conn.broadcast()
+	return nil
+}
+
+func (conn *DeviceConn) SetWriteDeadline(deadline time.Time) error {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	conn.writeDeadline = deadline
+	conn.broadcast()
+	return nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestDeviceConn returns a DeviceConn on a channel the device accepted.
+func newTestDeviceConn(t *testing.T) (*RemoteDevice, *testWriter, *DeviceConn) {
+	device, writer, _ := newTestDevice()
+
+	channel := device.createTCPChannel(62078, &testHandler{})
+	deliver(t, device, channel, TCPHeaderFlagSYN|TCPHeaderFlagACK, 1)
+	if state := channel.currentState(); state != TCPStateConnected {
+		t.Fatalf("channel in state %d after its SYN|ACK", state)
+	}
+
+	return device, writer, device.adoptChannel(channel)
+}
+
+// deliverData feeds data from the device to conn, which has received sent bytes so far.
+func deliverData(t *testing.T, device *RemoteDevice, conn *DeviceConn, flags uint16, sent uint32, data []byte) {
+	channel := conn.channel
+	deliverSegment(t, device, channel.sourcePort, channel.destinationPort, flags, 1+sent, channel.txSequence, 0xFFFF, data)
+}
+
+func TestDeviceConnReadDeadline(t *testing.T) {
+	_, _, conn := newTestDeviceConn(t)
+	buffer := make([]byte, 16)
+
+	conn.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := conn.Read(buffer); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a past deadline to fail the read, got %v", err)
+	}
+
+	// A blocked read gives up once the deadline passes
+	start := time.Now()
+	conn.SetReadDeadline(start.Add(50 * time.Millisecond))
+	if _, err := conn.Read(buffer); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the read to time out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("read returned after %s, before its deadline", elapsed)
+	}
+}
+
+func TestDeviceConnWriteDeadline(t *testing.T) {
+	device, _, conn := newTestDeviceConn(t)
+
+	conn.SetWriteDeadline(time.Now().Add(-time.Second))
+	if written, err := conn.Write([]byte("late")); written != 0 || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected a past deadline to fail the write, wrote %d with %v", written, err)
+	}
+
+	// With the device's window closed, a write blocks once the send buffer is full
+	channel := conn.channel
+	deliverSegment(t, device, channel.sourcePort, channel.destinationPort, TCPHeaderFlagACK, 1, channel.txSequence, 0, nil)
+
+	conn.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	written, err := conn.Write(make([]byte, 2*DeviceConnSendBufferSize))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("expected the write to time out, got %v", err)
+	}
+	if written != DeviceConnSendBufferSize {
+		t.Fatalf("expected the send buffer to be filled, wrote %d", written)
+	}
+}
+
+func TestDeviceConnReadAfterFIN(t *testing.T) {
+	device, _, conn := newTestDeviceConn(t)
+
+	deliverData(t, device, conn, TCPHeaderFlagACK, 0, []byte("hello"))
+	deliverData(t, device, conn, TCPHeaderFlagFIN|TCPHeaderFlagACK, 5, nil)
+
+	// Data received before the FIN is still read
+	data, err := ioutil.ReadAll(conn)
+	if err != nil {
+		t.Fatalf("expected io.EOF after the data, got %v", err)
+	}
+	if string(data) != "hello" {
+		t.Fatalf("expected the data sent before the FIN, got %q", data)
+	}
+	if _, err = conn.Read(make([]byte, 16)); err != io.EOF {
+		t.Fatalf("expected io.EOF again, got %v", err)
+	}
+}
+
+func TestDeviceConnCloseWrite(t *testing.T) {
+	device, writer, conn := newTestDeviceConn(t)
+
+	if err := conn.CloseWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	writer.mutex.Lock()
+	last := writer.packets[len(writer.packets)-1]
+	writer.mutex.Unlock()
+	if !last.header.hasFlag(TCPHeaderFlagFIN) {
+		t.Fatalf("expected CloseWrite to send a FIN")
+	}
+
+	if _, err := conn.Write([]byte("more")); err != io.ErrClosedPipe {
+		t.Fatalf("expected writes to fail after CloseWrite, got %v", err)
+	}
+
+	// The device can still send
+	deliverData(t, device, conn, TCPHeaderFlagACK, 0, []byte("reply"))
+	buffer := make([]byte, 16)
+	count, err := conn.Read(buffer)
+	if err != nil || string(buffer[:count]) != "reply" {
+		t.Fatalf("expected to read after CloseWrite, got %q (%v)", buffer[:count], err)
+	}
+}
+
+func TestDeviceConnCloseWakesRead(t *testing.T) {
+	_, _, conn := newTestDeviceConn(t)
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 16))
+		result <- err
+	}()
+
+	// Let the read block first
+	time.Sleep(20 * time.Millisecond)
+	conn.Close()
+
+	select {
+	case err := <-result:
+		if err != ErrDeviceConnClosed {
+			t.Fatalf("expected ErrDeviceConnClosed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read still blocked after Close")
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+)
+
+// How long the attach time lockdown queries may take
+const DeviceInfoTimeout = 30 * time.Second
+
+// DeviceInfo holds the lockdown values queried once when a device attaches.
+type DeviceInfo struct {
+	SerialNumber   string `json:"serialNumber"`
+	ProductID      int32  `json:"productId"`
+	UniqueDeviceID string `json:"uniqueDeviceId,omitempty"`
+	DeviceName     string `json:"deviceName,omitempty"`
+	DeviceClass    string `json:"deviceClass,omitempty"`
+	ProductType    string `json:"productType,omitempty"`
+	ProductVersion string `json:"productVersion,omitempty"`
+	BuildVersion   string `json:"buildVersion,omitempty"`
+	WiFiAddress    string `json:"wifiAddress,omitempty"`
+}
+
+// Lockdown keys cached in DeviceInfo
+var deviceInfoKeys = []string{
+	"DeviceName",
+	"ProductType",
+	"ProductVersion",
+	"BuildVersion",
+	"UniqueDeviceID",
+	"DeviceClass",
+	"WiFiAddress",
+}
+
+func (info *DeviceInfo) set(key string, value string) {
+	switch key {
+	case "DeviceName":
+		info.DeviceName = value
+	case "ProductType":
+		info.ProductType = value
+	case "ProductVersion":
+		info.ProductVersion = value
+	case "BuildVersion":
+		info.BuildVersion = value
+	case "UniqueDeviceID":
+		info.UniqueDeviceID = value
+	case "DeviceClass":
+		info.DeviceClass = value
+	case "WiFiAddress":
+		info.WiFiAddress = value
+	}
+}
+
+// getInfo returns a copy of the cached device information.
+func (device *RemoteDevice) getInfo() DeviceInfo {
+	device.infoMutex.Lock()
+	defer device.infoMutex.Unlock()
+
+	info := device.info
+	info.SerialNumber = device.serialNumber
+	info.ProductID = device.connectedMessage.GetProductId()
+	return info
+}
+
+// udid returns the device's UDID, falling back to the USB serial number until
+// lockdown reported the real one.
+func (device *RemoteDevice) udid() string {
+	device.infoMutex.Lock()
+	defer device.infoMutex.Unlock()
+
+	if device.info.UniqueDeviceID != "" {
+		return device.info.UniqueDeviceID
+	}
+	return device.serialNumber
+}
+
+// loadInfo queries lockdown for the values in deviceInfoKeys and publishes
+// them. Values lockdown only reveals inside a session are retried in one if
+// the host is paired.
+func (device *RemoteDevice) loadInfo() {
+	ctx, cancel := context.WithTimeout(context.Background(), DeviceInfoTimeout)
+	defer cancel()
+
+	lockdown := device.lockdown()
+
+	var missing []string
+	for _, key := range deviceInfoKeys {
+		if !device.loadInfoValue(ctx, lockdown, key) {
+			missing = append(missing, key)
+		}
+	}
+
+	if len(missing) > 0 {
+		if _, err := device.startSession(ctx, lockdown); err == nil {
+			for _, key := range missing {
+				device.loadInfoValue(ctx, lockdown, key)
+			}
+			lockdown.StopSession(ctx)
+		} else if !os.IsNotExist(err) {
+			fmt.Printf("RemoteDevice %s info session error %s\n", device.serialNumber, err)
+		}
+	}
+
+	info := device.getInfo()
+	fmt.Printf("RemoteDevice %s is %s (%s, iOS %s)\n", device.serialNumber, info.DeviceName, info.ProductType, info.ProductVersion)
+
+	device.hub.events <- &DeviceEvent{
+		Type:   DeviceEventInfo,
+		Device: device.serialNumber,
+		Info:   &info,
+	}
+}
+
+func (device *RemoteDevice) loadInfoValue(ctx context.Context, lockdown *LockdownService, key string) bool {
+	value, err := lockdown.GetValue(ctx, "", key)
+	if err != nil {
+		fmt.Printf("RemoteDevice %s GetValue %s error %s\n", device.serialNumber, key, err)
+		return false
+	}
+
+	text, ok := value.(string)
+	if !ok {
+		return false
+	}
+
+	device.infoMutex.Lock()
+	device.info.set(key, text)
+	device.infoMutex.Unlock()
+
+	return true
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// DeviceLink message names, the first element of every DeviceLink array
+const (
+	DeviceLinkMessageVersionExchange = "DLMessageVersionExchange"
+	DeviceLinkMessageDeviceReady     = "DLMessageDeviceReady"
+	DeviceLinkMessageProcessMessage  = "DLMessageProcessMessage"
+	DeviceLinkMessagePing            = "DLMessagePing"
+	DeviceLinkMessageDisconnect      = "DLMessageDisconnect"
+)
+
+var ErrDeviceLinkDisconnected = errors.New("device link disconnected by device")
+
+// DeviceLinkService speaks the DeviceLink protocol used by mobilebackup2,
+// screenshotr and mobilesync on top of a service connection. Messages are plist
+// arrays whose first element names the message.
+type DeviceLinkService struct {
+	service *ServiceConnection
+
+	// Protocol version agreed during the version exchange
+	versionMajor uint64
+	versionMinor uint64
+}
+
+// OpenDeviceLink starts name and performs the DeviceLink version exchange,
+// failing when the device's major version is newer than versionMajor.
+func (device *RemoteDevice) OpenDeviceLink(ctx context.Context, name string, versionMajor uint64) (*DeviceLinkService, error) {
+	service, err := device.OpenService(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &DeviceLinkService{service: service}
+	if err = link.versionExchange(ctx, versionMajor); err != nil {
+		service.Close()
+		return nil, err
+	}
+
+	return link, nil
+}
+
+func (link *DeviceLinkService) versionExchange(ctx context.Context, versionMajor uint64) error {
+	message, err := link.receive(ctx)
+	if err != nil {
+		return err
+	}
+	if len(message) < 3 || message[0] != DeviceLinkMessageVersionExchange {
+		return fmt.Errorf("device link expected %s, got %v", DeviceLinkMessageVersionExchange, message)
+	}
+
+	major, majorOk := plistInteger(message[1])
+	minor, minorOk := plistInteger(message[2])
+	if !majorOk || !minorOk {
+		return fmt.Errorf("device link malformed version %v", message[1:])
+	}
+	if major > versionMajor {
+		return fmt.Errorf("device link version %d.%d newer than supported %d", major, minor, versionMajor)
+	}
+
+	err = link.service.Send(ctx, []interface{}{DeviceLinkMessageVersionExchange, "DLVersionsOk", versionMajor})
+	if err != nil {
+		return err
+	}
+
+	message, err = link.receive(ctx)
+	if err != nil {
+		return err
+	}
+	if len(message) < 1 || message[0] != DeviceLinkMessageDeviceReady {
+		return fmt.Errorf("device link expected %s, got %v", DeviceLinkMessageDeviceReady, message)
+	}
+
+	link.versionMajor = major
+	link.versionMinor = minor
+	return nil
+}
+
+// receive reads one raw DeviceLink array.
+func (link *DeviceLinkService) receive(ctx context.Context) ([]interface{}, error) {
+	var value interface{}
+	if err := link.service.Receive(ctx, &value); err != nil {
+		return nil, err
+	}
+
+	message, ok := value.([]interface{})
+	if !ok || len(message) == 0 {
+		return nil, fmt.Errorf("device link unexpected %T message", value)
+	}
+	if _, ok = message[0].(string); !ok {
+		return nil, fmt.Errorf("device link message without name %v", message)
+	}
+
+	return message, nil
+}
+
+// ReceiveMessage reads the next message, skipping keepalive pings. A
+// disconnect from the device is returned as ErrDeviceLinkDisconnected.
+func (link *DeviceLinkService) ReceiveMessage(ctx context.Context) ([]interface{}, error) {
+	for {
+		message, err := link.receive(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		switch message[0] {
+		case DeviceLinkMessagePing:
+			continue
+		case DeviceLinkMessageDisconnect:
+			fmt.Printf("DeviceLink %s disconnected by device %v\n", link.service.info.Service, message[1:])
+			return nil, ErrDeviceLinkDisconnected
+		default:
+			return message, nil
+		}
+	}
+}
+
+// SendMessage sends a raw DeviceLink array named name.
+func (link *DeviceLinkService) SendMessage(ctx context.Context, name string, arguments ...interface{}) error {
+	return link.service.Send(ctx, append([]interface{}{name}, arguments...))
+}
+
+// SendProcessMessage wraps message in a DLMessageProcessMessage.
+func (link *DeviceLinkService) SendProcessMessage(ctx context.Context, message interface{}) error {
+	return link.SendMessage(ctx, DeviceLinkMessageProcessMessage, message)
+}
+
+// ReceiveProcessMessage waits for a DLMessageProcessMessage and returns its dictionary.
+func (link *DeviceLinkService) ReceiveProcessMessage(ctx context.Context) (map[string]interface{}, error) {
+	message, err := link.ReceiveMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if message[0] != DeviceLinkMessageProcessMessage || len(message) < 2 {
+		return nil, fmt.Errorf("device link expected %s, got %s", DeviceLinkMessageProcessMessage, message[0])
+	}
+
+	dictionary, ok := message[1].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("device link process message carries %T", message[1])
+	}
+
+	return dictionary, nil
+}
+
+// Ping sends a DLMessagePing, keeping the link alive during long host side work.
+func (link *DeviceLinkService) Ping(ctx context.Context, text string) error {
+	return link.SendMessage(ctx, DeviceLinkMessagePing, text)
+}
+
+// Disconnect tells the device the host is done and closes the connection.
+func (link *DeviceLinkService) Disconnect(ctx context.Context, reason string) error {
+	err := link.SendMessage(ctx, DeviceLinkMessageDisconnect, reason)
+	link.service.Close()
+	return err
+}
+
+func (link *DeviceLinkService) Close() error {
+	return link.service.Close()
+}
+
+// plistInteger returns a decoded plist number as an unsigned integer.
+func plistInteger(value interface{}) (uint64, bool) {
+	switch number := value.(type) {
+	case uint64:
+		return number, true
+	case int64:
+		return uint64(number), number >= 0
+	case float64:
+		return uint64(number), number >= 0
+	}
+	return 0, false
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"howett.net/plist"
+	"net"
+	"sync"
+	"time"
+)
+
+// ServiceConnection is a plist framed connection to a service started through
+// lockdown. Concrete service clients are built on top of it.
+//
+// Each message goes out as soon as it is sent, so replies are not held up by
+// coalescing on the channel.
+type ServiceConnection struct {
+	info *LockdownServiceInfo
+
+	// Plain or TLS stream to the service
+	conn net.Conn
+}
+
+// ServiceOptions adjust how the stream to a started service is set up.
+type ServiceOptions struct {
+	// Authenticate with the TLS handshake lockdown asks for, then carry on in
+	// plain text over the same connection ("SSL without session")
+	HandshakeOnly bool
+}
+
+// StartService asks lockdown to launch name. It uses a connection of its own so
+// the session it needs does not disturb other users of the device's lockdown client.
+func (device *RemoteDevice) StartService(ctx context.Context, name string) (*LockdownServiceInfo, error) {
+	lockdown := device.createLockdownService()
+	defer func() {
+		lockdown.close(ErrLockdownClosed)
+		if lockdown.propertyListService.channel != nil {
+			lockdown.propertyListService.channel.Close()
+		}
+	}()
+
+	record, err := device.startSession(ctx, lockdown)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := lockdown.StartService(ctx, name, record.EscrowBag)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("RemoteDevice %s started %s on port %d (SSL %t)\n", device.serialNumber, name, info.Port, info.EnableServiceSSL)
+
+	return info, nil
+}
+
+// OpenServiceStream starts name and connects to it, returning the raw stream.
+// The stream is TLS wrapped when lockdown asks for it.
+func (device *RemoteDevice) OpenServiceStream(ctx context.Context, name string) (net.Conn, *LockdownServiceInfo, error) {
+	return device.OpenServiceStreamWithOptions(ctx, name, ServiceOptions{})
+}
+
+// OpenServiceStreamWithOptions is OpenServiceStream for services that need
+// non default options.
+func (device *RemoteDevice) OpenServiceStreamWithOptions(ctx context.Context, name string, options ServiceOptions) (net.Conn, *LockdownServiceInfo, error) {
+	info, err := device.StartService(ctx, name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := device.Dial(ctx, info.Port)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !info.EnableServiceSSL {
+		return conn, info, nil
+	}
+
+	config, err := device.tlsConfig()
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	tlsConn := tlsClient(conn, config, options.HandshakeOnly)
+	stop := watchContext(ctx, tlsConn)
+	err = tlsConn.Handshake()
+	stop()
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, nil, ctx.Err()
+		}
+		return nil, nil, err
+	}
+
+	if options.HandshakeOnly {
+		fmt.Printf("RemoteDevice %s %s continues without TLS after the handshake\n", device.serialNumber, name)
+		return conn, info, nil
+	}
+
+	return tlsConn, info, nil
+}
+
+// tlsClient wraps conn in TLS. With handshakeOnly, conn is read one TLS record
+// at a time during the handshake, so plain text the service sends right after
+// its last handshake message stays in conn instead of the TLS read buffer.
+func tlsClient(conn net.Conn, config *tls.Config, handshakeOnly bool) *tls.Conn {
+	if handshakeOnly {
+		return tls.Client(&tlsRecordConn{Conn: conn}, config)
+	}
+	return tls.Client(conn, config)
+}
+
+// Size of a TLS record header: content type, version and length
+const tlsRecordHeaderSize = 5
+
+// tlsRecordConn never returns data past the end of the TLS record being read.
+type tlsRecordConn struct {
+	net.Conn
+
+	header     [tlsRecordHeaderSize]byte
+	headerRead int
+
+	// Body bytes left in the current record
+	remaining int
+}
+
+func (conn *tlsRecordConn) Read(data []byte) (int, error) {
+	if conn.remaining == 0 {
+		limit := tlsRecordHeaderSize - conn.headerRead
+		if len(data) < limit {
+			limit = len(data)
+		}
+
+		count, err := conn.Conn.Read(data[:limit])
+		copy(conn.header[conn.headerRead:], data[:count])
+		conn.headerRead += count
+		if conn.headerRead == tlsRecordHeaderSize {
+			conn.remaining = int(binary.BigEndian.Uint16(conn.header[3:]))
+			conn.headerRead = 0
+		}
+		return count, err
+	}
+
+	if len(data) > conn.remaining {
+		data = data[:conn.remaining]
+	}
+	count, err := conn.Conn.Read(data)
+	conn.remaining -= count
+	return count, err
+}
+
+// OpenService starts name and returns a plist framed connection to it.
+func (device *RemoteDevice) OpenService(ctx context.Context, name string) (*ServiceConnection, error) {
+	return device.OpenServiceWithOptions(ctx, name, ServiceOptions{})
+}
+
+// OpenServiceWithOptions is OpenService for services that need non default options.
+func (device *RemoteDevice) OpenServiceWithOptions(ctx context.Context, name string, options ServiceOptions) (*ServiceConnection, error) {
+	conn, info, err := device.OpenServiceStreamWithOptions(ctx, name, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ServiceConnection{info: info, conn: conn}, nil
+}
+
+// watchContext applies ctx's deadline and cancellation to conn until the
+// returned function is called. The function may be called more than once and
+// returns only once conn's deadline is no longer touched.
+func watchContext(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetDeadline)
+}
+
+// watchReadContext is watchContext for reads only, leaving a concurrent
+// writer's deadline alone.
+func watchReadContext(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetReadDeadline)
+}
+
+// watchWriteContext is watchContext for writes only, leaving a concurrent
+// reader's deadline alone.
+func watchWriteContext(ctx context.Context, conn net.Conn) func() {
+	return watchDeadline(ctx, conn.SetWriteDeadline)
+}
+
+func watchDeadline(ctx context.Context, setDeadline func(time.Time) error) func() {
+	deadline, _ := ctx.Deadline()
+	setDeadline(deadline)
+
+	if ctx.Done() == nil {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		select {
+		case <-ctx.Done():
+			// A deadline in the past wakes up blocked calls
+			setDeadline(time.Unix(1, 0))
+		case <-stop:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			close(stop)
+			<-done
+		})
+	}
+}
+
+// Send writes one plist message.
+func (service *ServiceConnection) Send(ctx context.Context, value interface{}) error {
+	data, err := encodePropertyList(value)
+	if err != nil {
+		return err
+	}
+
+	// Only the write deadline, the connection may be read concurrently
+	stop := watchWriteContext(ctx, service.conn)
+	defer stop()
+
+	_, err = service.conn.Write(data)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Receive reads one plist message and unmarshals it into value.
+func (service *ServiceConnection) Receive(ctx context.Context, value interface{}) error {
+	stop := watchReadContext(ctx, service.conn)
+	data, err := readPropertyList(service.conn)
+	stop()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	_, err = plist.Unmarshal(data, value)
+	return err
+}
+
+// Call sends request and reads the reply into reply.
+func (service *ServiceConnection) Call(ctx context.Context, request interface{}, reply interface{}) error {
+	if err := service.Send(ctx, request); err != nil {
+		return err
+	}
+
+	return service.Receive(ctx, reply)
+}
+
+func (service *ServiceConnection) Close() error {
+	return service.conn.Close()
+}
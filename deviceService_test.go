@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestServiceConnectionSendKeepsReadDeadline(t *testing.T) {
+	host, device := net.Pipe()
+	defer host.Close()
+	defer device.Close()
+	service := &ServiceConnection{conn: host}
+
+	// A long running reader, like the notification_proxy relay
+	received := make(chan error, 1)
+	go func() {
+		message := &NotificationProxyMessage{}
+		received <- service.Receive(context.Background(), message)
+	}()
+
+	// Let the reader block before the send sets its deadline
+	time.Sleep(20 * time.Millisecond)
+
+	go readPropertyList(device)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	if err := service.Send(ctx, &NotificationProxyMessage{Command: "ObserveNotification", Name: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Neither the send's deadline passing nor its context ending stops the reader
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+	select {
+	case err := <-received:
+		t.Fatalf("receive ended by the send's context: %v", err)
+	default:
+	}
+
+	data, err := encodePropertyList(&NotificationProxyMessage{Command: "RelayNotification", Name: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = device.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-received; err != nil {
+		t.Fatalf("receive failed: %v", err)
+	}
+}
+
+// testCoalescingConn holds back writes until the next read or flush, so
+// separate writes reach the peer as one.
+type testCoalescingConn struct {
+	net.Conn
+
+	mutex   sync.Mutex
+	pending []byte
+}
+
+func (conn *testCoalescingConn) Write(data []byte) (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.pending = append(conn.pending, data...)
+	return len(data), nil
+}
+
+func (conn *testCoalescingConn) Read(data []byte) (int, error) {
+	if err := conn.flush(); err != nil {
+		return 0, err
+	}
+	return conn.Conn.Read(data)
+}
+
+func (conn *testCoalescingConn) flush() error {
+	conn.mutex.Lock()
+	pending := conn.pending
+	conn.pending = nil
+	conn.mutex.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+	_, err := conn.Conn.Write(pending)
+	return err
+}
+
+func TestTLSHandshakeOnlyKeepsFollowingData(t *testing.T) {
+	record, serverConfig := testPairing(t)
+	serverConfig.MaxVersion = tls.VersionTLS12
+	clientConfig, err := record.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host, device := net.Pipe()
+	defer host.Close()
+	defer device.Close()
+
+	// The device's last handshake record and its first plain text arrive together
+	served := make(chan error, 1)
+	go func() {
+		coalescing := &testCoalescingConn{Conn: device}
+		if err := tls.Server(coalescing, serverConfig).Handshake(); err != nil {
+			served <- err
+			return
+		}
+		coalescing.Write([]byte("plain"))
+		served <- coalescing.flush()
+	}()
+
+	host.SetDeadline(time.Now().Add(5 * time.Second))
+	if err = tlsClient(host, clientConfig, true).Handshake(); err != nil {
+		t.Fatal(err)
+	}
+
+	data := make([]byte, 5)
+	if _, err = io.ReadFull(host, data); err != nil {
+		t.Fatalf("plain text after the handshake lost: %v", err)
+	}
+	if string(data) != "plain" {
+		t.Fatalf("expected the plain text, got %q", data)
+	}
+	if err = <-served; err != nil {
+		t.Fatal(err)
+	}
+}
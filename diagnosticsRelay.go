@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const DiagnosticsRelayServiceName = "com.apple.mobile.diagnostics_relay"
+
+// How long Close waits for the reply to Goodbye
+const DiagnosticsRelayGoodbyeTimeout = 5 * time.Second
+
+// Status values of diagnostics_relay replies
+const (
+	DiagnosticsRelayStatusSuccess        = "Success"
+	DiagnosticsRelayStatusFailure        = "Failure"
+	DiagnosticsRelayStatusUnknownRequest = "UnknownRequest"
+)
+
+// DiagnosticsRelayError is a reply whose Status is not Success.
+type DiagnosticsRelayError struct {
+	Request string
+	Status  string
+}
+
+func (err *DiagnosticsRelayError) Error() string {
+	return fmt.Sprintf("diagnostics_relay %s failed: %s", err.Request, err.Status)
+}
+
+type DiagnosticsRelayRequest struct {
+	Request           string   `plist:"Request"`
+	WaitForDisconnect bool     `plist:"WaitForDisconnect,omitempty"`
+	DisplayPass       bool     `plist:"DisplayPass,omitempty"`
+	DisplayFail       bool     `plist:"DisplayFail,omitempty"`
+	CurrentPlane      string   `plist:"CurrentPlane,omitempty"`
+	EntryName         string   `plist:"EntryName,omitempty"`
+	EntryClass        string   `plist:"EntryClass,omitempty"`
+	MobileGestaltKeys []string `plist:"MobileGestaltKeys,omitempty"`
+}
+
+type DiagnosticsRelayReply struct {
+	Status      string                 `plist:"Status"`
+	Diagnostics map[string]interface{} `plist:"Diagnostics"`
+}
+
+type DiagnosticsRelayClient struct {
+	service *ServiceConnection
+
+	// Set once the device is going down and will not answer a Goodbye
+	goingDown bool
+}
+
+func (device *RemoteDevice) OpenDiagnosticsRelay(ctx context.Context) (*DiagnosticsRelayClient, error) {
+	service, err := device.OpenService(ctx, DiagnosticsRelayServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DiagnosticsRelayClient{service: service}, nil
+}
+
+// Close says goodbye to the service before closing the connection, unless the
+// device was restarted or shut down.
+func (client *DiagnosticsRelayClient) Close() error {
+	if !client.goingDown {
+		ctx, cancel := context.WithTimeout(context.Background(), DiagnosticsRelayGoodbyeTimeout)
+		client.call(ctx, &DiagnosticsRelayRequest{Request: "Goodbye"})
+		cancel()
+	}
+	return client.service.Close()
+}
+
+func (client *DiagnosticsRelayClient) call(ctx context.Context, request *DiagnosticsRelayRequest) (map[string]interface{}, error) {
+	reply := &DiagnosticsRelayReply{}
+	if err := client.service.Call(ctx, request, reply); err != nil {
+		return nil, err
+	}
+
+	if reply.Status != DiagnosticsRelayStatusSuccess {
+		return nil, &DiagnosticsRelayError{Request: request.Request, Status: reply.Status}
+	}
+
+	return reply.Diagnostics, nil
+}
+
+// Restart reboots the device. With waitForDisconnect the device waits for the
+// connection to close before going down.
+func (client *DiagnosticsRelayClient) Restart(ctx context.Context, waitForDisconnect bool) error {
+	_, err := client.call(ctx, &DiagnosticsRelayRequest{Request: "Restart", WaitForDisconnect: waitForDisconnect})
+	client.goingDown = err == nil
+	return err
+}
+
+func (client *DiagnosticsRelayClient) Shutdown(ctx context.Context, waitForDisconnect bool) error {
+	_, err := client.call(ctx, &DiagnosticsRelayRequest{Request: "Shutdown", WaitForDisconnect: waitForDisconnect})
+	client.goingDown = err == nil
+	return err
+}
+
+func (client *DiagnosticsRelayClient) Sleep(ctx context.Context) error {
+	_, err := client.call(ctx, &DiagnosticsRelayRequest{Request: "Sleep"})
+	return err
+}
+
+// IORegistry returns the registry entries matching plane, name and class, any of
+// which may be empty.
+func (client *DiagnosticsRelayClient) IORegistry(ctx context.Context, plane string, name string, class string) (map[string]interface{}, error) {
+	return client.call(ctx, &DiagnosticsRelayRequest{
+		Request:      "IORegistry",
+		CurrentPlane: plane,
+		EntryName:    name,
+		EntryClass:   class,
+	})
+}
+
+// MobileGestalt queries keys, returning the MobileGestalt dictionary of the reply.
+func (client *DiagnosticsRelayClient) MobileGestalt(ctx context.Context, keys []string) (map[string]interface{}, error) {
+	diagnostics, err := client.call(ctx, &DiagnosticsRelayRequest{Request: "MobileGestalt", MobileGestaltKeys: keys})
+	if err != nil {
+		return nil, err
+	}
+
+	values, _ := diagnostics["MobileGestalt"].(map[string]interface{})
+	return values, nil
+}
+
+// handleDiagnostics serves the diagnostics_relay endpoints:
+//
+//	POST /v1/devices/{id}/diagnostics/restart?wait=true
+//	POST /v1/devices/{id}/diagnostics/shutdown?wait=true
+//	POST /v1/devices/{id}/diagnostics/sleep
+//	GET  /v1/devices/{id}/diagnostics/ioregistry?plane=IODeviceTree&name=...&class=...
+//	GET  /v1/devices/{id}/diagnostics/gestalt?keys=ProductType,HasBaseband
+func handleDiagnostics(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	query := request.URL.Query()
+
+	method := http.MethodPost
+	if path == "ioregistry" || path == "gestalt" {
+		method = http.MethodGet
+	}
+	if request.Method != method {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on diagnostics/%s", request.Method, path))
+		return
+	}
+
+	client, err := device.OpenDiagnosticsRelay(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	var result interface{}
+	wait := query.Get("wait") == "true" || query.Get("wait") == "1"
+
+	switch path {
+	case "restart":
+		err = client.Restart(request.Context(), wait)
+	case "shutdown":
+		err = client.Shutdown(request.Context(), wait)
+	case "sleep":
+		err = client.Sleep(request.Context())
+	case "ioregistry":
+		result, err = client.IORegistry(request.Context(), query.Get("plane"), query.Get("name"), query.Get("class"))
+	case "gestalt":
+		if query.Get("keys") == "" {
+			writeError(writer, http.StatusBadRequest, fmt.Errorf("missing keys parameter"))
+			return
+		}
+		result, err = client.MobileGestalt(request.Context(), strings.Split(query.Get("keys"), ","))
+	default:
+		writeError(writer, http.StatusNotFound, fmt.Errorf("unknown diagnostics request %s", path))
+		return
+	}
+
+	if err != nil {
+		writeError(writer, diagnosticsErrorStatus(err), err)
+		return
+	}
+
+	if result == nil {
+		result = map[string]string{"status": DiagnosticsRelayStatusSuccess}
+	}
+	writeJSON(writer, http.StatusOK, result)
+}
+
+// diagnosticsErrorStatus tells a refusal by the device apart from a failure to reach it.
+func diagnosticsErrorStatus(err error) int {
+	var relayErr *DiagnosticsRelayError
+	if errors.As(err, &relayErr) {
+		switch relayErr.Status {
+		case DiagnosticsRelayStatusUnknownRequest:
+			return http.StatusNotImplemented
+		default:
+			return http.StatusConflict
+		}
+	}
+	return http.StatusBadGateway
+}
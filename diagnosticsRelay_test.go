@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestDiagnosticsRelayCloseAfterRestart(t *testing.T) {
+	host, conn := net.Pipe()
+	defer conn.Close()
+
+	client := &DiagnosticsRelayClient{service: &ServiceConnection{conn: host}}
+	device := &ServiceConnection{conn: conn}
+
+	// The device answers the restart, then only watches for further requests
+	requests := make(chan string, 2)
+	go func() {
+		for {
+			request := &DiagnosticsRelayRequest{}
+			if err := device.Receive(context.Background(), request); err != nil {
+				close(requests)
+				return
+			}
+			requests <- request.Request
+			if request.Request == "Restart" {
+				device.Send(context.Background(), &DiagnosticsRelayReply{Status: DiagnosticsRelayStatusSuccess})
+			}
+		}
+	}()
+
+	if err := client.Restart(context.Background(), true); err != nil {
+		t.Fatal(err)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- client.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(DiagnosticsRelayGoodbyeTimeout / 2):
+		t.Fatalf("Close is waiting on a device that is going down")
+	}
+
+	var sent []string
+	for request := range requests {
+		sent = append(sent, request)
+	}
+	if len(sent) != 1 || sent[0] != "Restart" {
+		t.Fatalf("expected only Restart to be sent, got %v", sent)
+	}
+}
@@ -1,13 +1,18 @@
 module git.t8012.dev/t8012dev/webmuxd
 
-go 1.15
+go 1.18
 
 require (
 	github.com/google/uuid v1.1.2
 	github.com/gorilla/websocket v1.4.2
-	github.com/pkg/errors v0.9.1 // indirect
-	github.com/stretchr/testify v1.6.1 // indirect
+	golang.org/x/image v0.18.0
 	google.golang.org/protobuf v1.25.0
 	gopkg.in/restruct.v1 v1.0.0-20190323193435-3c2afb705f3c
 	howett.net/plist v0.0.0-20200419221736-3b63eb3a43b5
 )
+
+require (
+	github.com/google/go-cmp v0.6.0 // indirect
+	github.com/pkg/errors v0.9.1 // indirect
+	github.com/stretchr/testify v1.6.1 // indirect
+)
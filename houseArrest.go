@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const HouseArrestServiceName = "com.apple.mobile.house_arrest"
+
+// house_arrest commands selecting what of an app the AFC session exposes
+const (
+	// The whole app container, only for apps signed with get-task-allow
+	HouseArrestVendContainer = "VendContainer"
+
+	// The Documents folder of apps sharing files with iTunes
+	HouseArrestVendDocuments = "VendDocuments"
+)
+
+// HouseArrestError is a house_arrest reply carrying an Error.
+type HouseArrestError struct {
+	Command    string
+	Identifier string
+	Code       string
+}
+
+func (err *HouseArrestError) Error() string {
+	return fmt.Sprintf("house_arrest %s %s failed: %s", err.Command, err.Identifier, err.Code)
+}
+
+type HouseArrestRequest struct {
+	Command    string `plist:"Command"`
+	Identifier string `plist:"Identifier"`
+}
+
+type HouseArrestReply struct {
+	Status string `plist:"Status"`
+	Error  string `plist:"Error"`
+}
+
+// OpenHouseArrest starts house_arrest, vends bundleID's container or documents
+// with command and returns an AFC client on the same stream.
+func (device *RemoteDevice) OpenHouseArrest(ctx context.Context, command string, bundleID string) (*AFCClient, error) {
+	service, err := device.OpenService(ctx, HouseArrestServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &HouseArrestReply{}
+	if err = service.Call(ctx, &HouseArrestRequest{Command: command, Identifier: bundleID}, reply); err != nil {
+		service.Close()
+		return nil, err
+	}
+
+	if reply.Error != "" || reply.Status != "Complete" {
+		service.Close()
+		code := reply.Error
+		if code == "" {
+			code = reply.Status
+		}
+		return nil, &HouseArrestError{Command: command, Identifier: bundleID, Code: code}
+	}
+
+	// From here on the service speaks AFC
+	return newAFCClient(service.conn), nil
+}
+
+// handleContainers serves app containers under
+// /v1/devices/{id}/containers/{bundleID}/{path} like the media files endpoint.
+func handleContainers(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	serveHouseArrest(device, HouseArrestVendContainer, writer, request, path)
+}
+
+// handleDocuments serves app Documents folders under
+// /v1/devices/{id}/documents/{bundleID}/{path}.
+func handleDocuments(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	serveHouseArrest(device, HouseArrestVendDocuments, writer, request, path)
+}
+
+func serveHouseArrest(device *RemoteDevice, command string, writer http.ResponseWriter, request *http.Request, path string) {
+	bundleID, filePath := path, ""
+	if index := strings.IndexByte(path, '/'); index >= 0 {
+		bundleID, filePath = path[:index], path[index+1:]
+	}
+	if bundleID == "" {
+		writeError(writer, http.StatusBadRequest, fmt.Errorf("missing bundle identifier"))
+		return
+	}
+
+	client, err := device.OpenHouseArrest(request.Context(), command, bundleID)
+	if err != nil {
+		status := http.StatusBadGateway
+		var houseArrestErr *HouseArrestError
+		if errors.As(err, &houseArrestErr) {
+			status = http.StatusNotFound
+		}
+		writeError(writer, status, err)
+		return
+	}
+	defer client.Close()
+
+	serveAFC(client, writer, request, filePath)
+}
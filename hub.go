@@ -5,6 +5,7 @@ import (
 	"github.com/gorilla/websocket"
 	"net"
 	"net/http"
+	"sync"
 )
 
 // Hub maintains the set of active clients and broadcasts messages to the
@@ -13,6 +14,9 @@ type Hub struct {
 	// Local client listening socket
 	localSocket *net.Listener
 
+	// Host side pair records used to authenticate to devices
+	pairRecords *PairRecordStore
+
 	// Remote connection WS upgrader
 	upgrader *websocket.Upgrader
 
@@ -23,6 +27,8 @@ type Hub struct {
 	// TODO: this is insecure because the key is the serial number from the client
 	devices map[string]*RemoteDevice
 
+	devicesMutex sync.RWMutex
+
 	// Local clients
 	clients map[*net.Conn]*LocalClient
 
@@ -39,12 +45,22 @@ type Hub struct {
 
 	localDisconnected chan *LocalClient
 
+	// Management API event subscribers (Set)
+	subscribers map[*EventSubscriber]bool
+
+	subscribe chan *EventSubscriber
+
+	unsubscribe chan *EventSubscriber
+
+	// Device events to publish to subscribers
+	events chan *DeviceEvent
+
 	close chan bool
 
 	open bool
 }
 
-func newHub(localSocket *net.Listener) *Hub {
+func newHub(localSocket *net.Listener, pairRecords *PairRecordStore) *Hub {
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(request *http.Request) bool {
 			return true
@@ -55,6 +71,7 @@ func newHub(localSocket *net.Listener) *Hub {
 
 	return &Hub{
 		localSocket:        localSocket,
+		pairRecords:        pairRecords,
 		devices:            make(map[string]*RemoteDevice),
 		remoteConnections:  make(map[*RemoteConnection]bool),
 		upgrader:           &upgrader,
@@ -65,6 +82,10 @@ func newHub(localSocket *net.Listener) *Hub {
 		remoteDisconnected: make(chan *RemoteConnection),
 		localConnected:     make(chan *LocalClient),
 		localDisconnected:  make(chan *LocalClient),
+		subscribers:        make(map[*EventSubscriber]bool),
+		subscribe:          make(chan *EventSubscriber),
+		unsubscribe:        make(chan *EventSubscriber),
+		events:             make(chan *DeviceEvent),
 		close:              make(chan bool),
 		open:               true,
 	}
@@ -74,12 +95,16 @@ func (hub *Hub) run() {
 	for {
 		select {
 		case device := <-hub.deviceAttached:
+			hub.devicesMutex.Lock()
 			hub.devices[device.serialNumber] = device
+			hub.devicesMutex.Unlock()
 			for _, localClient := range hub.clients {
 				localClient.deviceAttached <- device
 			}
 		case device := <-hub.deviceRemoved:
+			hub.devicesMutex.Lock()
 			hub.devices[device.serialNumber] = nil
+			hub.devicesMutex.Unlock()
 			for _, localClient := range hub.clients {
 				localClient.deviceRemoved <- device
 			}
@@ -91,6 +116,15 @@ func (hub *Hub) run() {
 			hub.clients[local.connection] = local
 		case local := <-hub.localDisconnected:
 			hub.clients[local.connection] = nil
+		case subscriber := <-hub.subscribe:
+			hub.subscribers[subscriber] = true
+		case subscriber := <-hub.unsubscribe:
+			if hub.subscribers[subscriber] {
+				delete(hub.subscribers, subscriber)
+				close(subscriber.send)
+			}
+		case event := <-hub.events:
+			hub.publishEvent(event)
 		case <-hub.close:
 			hub.open = false
 		}
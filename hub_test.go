@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func dialRemote(t *testing.T, server *httptest.Server) *websocket.Conn {
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return connection
+}
+
+func sendServerMessage(t *testing.T, connection *websocket.Conn, message *ServerMessage) {
+	data, err := proto.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = connection.WriteMessage(websocket.BinaryMessage, data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func expectEvent(t *testing.T, subscriber *EventSubscriber, eventType string, serialNumber string) {
+	select {
+	case event := <-subscriber.send:
+		if event.Type != eventType || event.Device != serialNumber {
+			t.Fatalf("expected %s for %s, got %s for %s", eventType, serialNumber, event.Type, event.Device)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("no %s event for %s", eventType, serialNumber)
+	}
+}
+
+func TestDataForRemovedDevice(t *testing.T) {
+	hub := newHub(nil, nil)
+	go hub.run()
+
+	subscriber := &EventSubscriber{send: make(chan *DeviceEvent, 16)}
+	hub.subscribe <- subscriber
+
+	server := httptest.NewServer(http.HandlerFunc(hub.handleRemoteConnection))
+	defer server.Close()
+
+	first := dialRemote(t, server)
+	sendServerMessage(t, first, &ServerMessage{Message: &ServerMessage_DeviceConnected{
+		DeviceConnected: &DeviceConnected{SerialNumber: "A"},
+	}})
+	expectEvent(t, subscriber, DeviceEventAttached, "A")
+
+	first.Close()
+	expectEvent(t, subscriber, DeviceEventRemoved, "A")
+
+	// A late transfer for the removed device must not take down the other
+	// connection's reader
+	second := dialRemote(t, server)
+	defer second.Close()
+	sendServerMessage(t, second, &ServerMessage{Message: &ServerMessage_FromDevice{
+		FromDevice: &DataFromDevice{SerialNumber: "A", Data: make([]byte, USBMuxDHeaderSize)},
+	}})
+	sendServerMessage(t, second, &ServerMessage{Message: &ServerMessage_DeviceConnected{
+		DeviceConnected: &DeviceConnected{SerialNumber: "B"},
+	}})
+	expectEvent(t, subscriber, DeviceEventAttached, "B")
+}
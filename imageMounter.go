@@ -0,0 +1,448 @@
+package main
+
+import (
+	"context"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const ImageMounterServiceName = "com.apple.mobile.mobile_image_mounter"
+
+// Image types understood by mobile_image_mounter
+const (
+	ImageTypeDeveloper    = "Developer"
+	ImageTypePersonalized = "Personalized"
+
+	// Personalization image type of the iOS 17 and later developer image
+	PersonalizedImageTypeDeveloper = "DeveloperDiskImage"
+)
+
+// Mount point of the developer image
+const DeveloperImageMountPath = "/Developer"
+
+// First iOS major version that only accepts personalized developer images
+const personalizedImageMinimumVersion = 17
+
+// Files making up the images below the -images directory. Developer images live
+// in a directory named after the iOS version like Xcode's DeviceSupport, the
+// personalized image in a directory of its own like Xcode's iOS_DDI.
+const (
+	developerImageName          = "DeveloperDiskImage.dmg"
+	developerImageSignatureName = "DeveloperDiskImage.dmg.signature"
+
+	personalizedImageDirectory = "Personalized"
+	personalizedImageName      = "Image.dmg"
+	personalizedTrustCacheName = "Image.dmg.trustcache"
+	personalizedManifestName   = "Image.dmg.manifest"
+)
+
+var ErrImageAlreadyMounted = errors.New("an image of this type is already mounted")
+
+// ImageMounterError is a mobile_image_mounter reply carrying an Error.
+type ImageMounterError struct {
+	Command       string
+	Code          string
+	DetailedError string
+}
+
+func (err *ImageMounterError) Error() string {
+	if err.DetailedError != "" {
+		return fmt.Sprintf("mobile_image_mounter %s failed: %s (%s)", err.Command, err.Code, err.DetailedError)
+	}
+	return fmt.Sprintf("mobile_image_mounter %s failed: %s", err.Command, err.Code)
+}
+
+type ImageMounterClient struct {
+	service *ServiceConnection
+}
+
+func (device *RemoteDevice) OpenImageMounter(ctx context.Context) (*ImageMounterClient, error) {
+	service, err := device.OpenService(ctx, ImageMounterServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageMounterClient{service: service}, nil
+}
+
+// Close hangs up before closing the connection.
+func (client *ImageMounterClient) Close() error {
+	client.service.Send(context.Background(), map[string]interface{}{"Command": "Hangup"})
+	return client.service.Close()
+}
+
+// call sends request and returns the reply, turning an Error or an Error status
+// into an *ImageMounterError.
+func (client *ImageMounterClient) call(ctx context.Context, request map[string]interface{}) (map[string]interface{}, error) {
+	reply := map[string]interface{}{}
+	if err := client.service.Call(ctx, request, &reply); err != nil {
+		return nil, err
+	}
+
+	code, _ := reply["Error"].(string)
+	if status, _ := reply["Status"].(string); code == "" && status == "Error" {
+		code = status
+	}
+	if code != "" {
+		detailed, _ := reply["DetailedError"].(string)
+		return reply, &ImageMounterError{Command: request["Command"].(string), Code: code, DetailedError: detailed}
+	}
+
+	return reply, nil
+}
+
+// CopyDevices lists the mounted images.
+func (client *ImageMounterClient) CopyDevices(ctx context.Context) ([]interface{}, error) {
+	reply, err := client.call(ctx, map[string]interface{}{"Command": "CopyDevices"})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, _ := reply["EntryList"].([]interface{})
+	return entries, nil
+}
+
+// LookupImage returns the signatures of the mounted images of imageType, none
+// when no such image is mounted.
+func (client *ImageMounterClient) LookupImage(ctx context.Context, imageType string) ([][]byte, error) {
+	reply, err := client.call(ctx, map[string]interface{}{"Command": "LookupImage", "ImageType": imageType})
+	if err != nil {
+		return nil, err
+	}
+
+	// Older versions answer with a single signature
+	switch signature := reply["ImageSignature"].(type) {
+	case []byte:
+		return [][]byte{signature}, nil
+	case []interface{}:
+		var signatures [][]byte
+		for _, value := range signature {
+			if data, ok := value.([]byte); ok {
+				signatures = append(signatures, data)
+			}
+		}
+		return signatures, nil
+	}
+
+	return nil, nil
+}
+
+// UploadImage transfers size bytes of image to the device ahead of MountImage.
+func (client *ImageMounterClient) UploadImage(ctx context.Context, imageType string, image io.Reader, size int64, signature []byte) error {
+	reply, err := client.call(ctx, map[string]interface{}{
+		"Command":        "ReceiveBytes",
+		"ImageType":      imageType,
+		"ImageSize":      uint64(size),
+		"ImageSignature": signature,
+	})
+	if err != nil {
+		return err
+	}
+	if status, _ := reply["Status"].(string); status != "ReceiveBytesAck" {
+		return &ImageMounterError{Command: "ReceiveBytes", Code: status}
+	}
+
+	stop := watchContext(ctx, client.service.conn)
+	_, err = io.CopyN(client.service.conn, image, size)
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	reply = map[string]interface{}{}
+	if err = client.service.Receive(ctx, &reply); err != nil {
+		return err
+	}
+	if status, _ := reply["Status"].(string); status != "Complete" {
+		code, _ := reply["Error"].(string)
+		if code == "" {
+			code = status
+		}
+		return &ImageMounterError{Command: "ReceiveBytes", Code: code}
+	}
+
+	return nil
+}
+
+// MountImage mounts the uploaded image of imageType. extras carries the
+// additional keys personalized images need.
+func (client *ImageMounterClient) MountImage(ctx context.Context, imageType string, signature []byte, extras map[string]interface{}) error {
+	request := map[string]interface{}{
+		"Command":        "MountImage",
+		"ImageType":      imageType,
+		"ImageSignature": signature,
+	}
+	for key, value := range extras {
+		request[key] = value
+	}
+
+	_, err := client.call(ctx, request)
+	return err
+}
+
+func (client *ImageMounterClient) UnmountImage(ctx context.Context, mountPath string) error {
+	_, err := client.call(ctx, map[string]interface{}{"Command": "UnmountImage", "MountPath": mountPath})
+	return err
+}
+
+// QueryPersonalizationManifest returns the manifest the device holds for the
+// personalized image with the given SHA-384 digest.
+func (client *ImageMounterClient) QueryPersonalizationManifest(ctx context.Context, personalizedType string, digest []byte) ([]byte, error) {
+	reply, err := client.call(ctx, map[string]interface{}{
+		"Command":               "QueryPersonalizationManifest",
+		"PersonalizedImageType": personalizedType,
+		"ImageType":             personalizedType,
+		"ImageSignature":        digest,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, ok := reply["ImageSignature"].([]byte)
+	if !ok {
+		return nil, &ImageMounterError{Command: "QueryPersonalizationManifest", Code: "MissingManifest"}
+	}
+	return manifest, nil
+}
+
+// MountDeveloperImage uploads and mounts the signed developer image matching
+// productVersion found below directory.
+func (client *ImageMounterClient) MountDeveloperImage(ctx context.Context, directory string, productVersion string) error {
+	imageDirectory, err := developerImageDirectory(directory, productVersion)
+	if err != nil {
+		return err
+	}
+
+	signature, err := ioutil.ReadFile(filepath.Join(imageDirectory, developerImageSignatureName))
+	if err != nil {
+		return err
+	}
+
+	if err = client.uploadFile(ctx, ImageTypeDeveloper, filepath.Join(imageDirectory, developerImageName), signature); err != nil {
+		return err
+	}
+
+	return client.MountImage(ctx, ImageTypeDeveloper, signature, nil)
+}
+
+// MountPersonalizedImage uploads and mounts the personalized developer image
+// below directory. The manifest personalizing it is the one the device already
+// holds, or a previously saved one next to the image; signing a new one with
+// Apple's TSS server is not supported.
+func (client *ImageMounterClient) MountPersonalizedImage(ctx context.Context, directory string) error {
+	imageDirectory := filepath.Join(directory, personalizedImageDirectory)
+	imagePath := filepath.Join(imageDirectory, personalizedImageName)
+
+	trustCache, err := ioutil.ReadFile(filepath.Join(imageDirectory, personalizedTrustCacheName))
+	if err != nil {
+		return err
+	}
+
+	digest, err := fileDigest(imagePath)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := client.QueryPersonalizationManifest(ctx, PersonalizedImageTypeDeveloper, digest)
+	if err != nil {
+		var mounterErr *ImageMounterError
+		if !errors.As(err, &mounterErr) {
+			return err
+		}
+
+		saved, readErr := ioutil.ReadFile(filepath.Join(imageDirectory, personalizedManifestName))
+		if readErr != nil {
+			return fmt.Errorf("device has no personalization manifest for the image and none is saved: %w", err)
+		}
+		manifest = saved
+	}
+
+	if err = client.uploadFile(ctx, ImageTypePersonalized, imagePath, manifest); err != nil {
+		return err
+	}
+
+	return client.MountImage(ctx, ImageTypePersonalized, manifest, map[string]interface{}{
+		"ImageTrustCache": trustCache,
+	})
+}
+
+func (client *ImageMounterClient) uploadFile(ctx context.Context, imageType string, path string, signature []byte) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	return client.UploadImage(ctx, imageType, file, info.Size(), signature)
+}
+
+// developerImageDirectory finds the image for productVersion, preferring an exact
+// match over one for its major.minor version.
+func developerImageDirectory(directory string, productVersion string) (string, error) {
+	candidates := []string{productVersion}
+	if parts := strings.SplitN(productVersion, ".", 3); len(parts) == 3 {
+		candidates = append(candidates, parts[0]+"."+parts[1])
+	}
+
+	for _, candidate := range candidates {
+		path := filepath.Join(directory, candidate)
+		if _, err := os.Stat(filepath.Join(path, developerImageName)); err == nil {
+			return path, nil
+		}
+	}
+
+	return "", fmt.Errorf("no developer image for iOS %s in %s: %w", productVersion, directory, os.ErrNotExist)
+}
+
+// fileDigest returns the SHA-384 digest of the file at path.
+func fileDigest(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hash := sha512.New384()
+	if _, err = io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+
+	return hash.Sum(nil), nil
+}
+
+// usesPersonalizedImages reports whether productVersion only accepts personalized images.
+func usesPersonalizedImages(productVersion string) bool {
+	major, err := strconv.Atoi(strings.SplitN(productVersion, ".", 2)[0])
+	return err == nil && major >= personalizedImageMinimumVersion
+}
+
+// handleImages serves the mobile_image_mounter endpoints, reading images from
+// the -images directory:
+//
+//	GET    /v1/devices/{id}/images
+//	GET    /v1/devices/{id}/images/lookup?type=Developer
+//	POST   /v1/devices/{id}/images/mount
+//	DELETE /v1/devices/{id}/images?path=/Developer
+func handleImages(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	if !imagesRequestAllowed(request.Method, path) {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on images/%s", request.Method, path))
+		return
+	}
+
+	client, err := device.OpenImageMounter(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	query := request.URL.Query()
+	productVersion := device.getInfo().ProductVersion
+
+	switch {
+	case request.Method == http.MethodGet && path == "":
+		entries, err := client.CopyDevices(request.Context())
+		if err != nil {
+			writeError(writer, imageMounterErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, entries)
+
+	case request.Method == http.MethodGet && path == "lookup":
+		imageType := query.Get("type")
+		if imageType == "" {
+			imageType = ImageTypeDeveloper
+			if usesPersonalizedImages(productVersion) {
+				imageType = ImageTypePersonalized
+			}
+		}
+
+		signatures, err := client.LookupImage(request.Context(), imageType)
+		if err != nil {
+			writeError(writer, imageMounterErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{"type": imageType, "signatures": signatures})
+
+	case request.Method == http.MethodPost && path == "mount":
+		personalized := usesPersonalizedImages(productVersion)
+		imageType := ImageTypeDeveloper
+		if personalized {
+			imageType = ImageTypePersonalized
+		}
+
+		signatures, err := client.LookupImage(request.Context(), imageType)
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+
+		if len(signatures) > 0 {
+			err = ErrImageAlreadyMounted
+		} else if personalized {
+			err = client.MountPersonalizedImage(request.Context(), *imagesFlag)
+		} else {
+			err = client.MountDeveloperImage(request.Context(), *imagesFlag, productVersion)
+		}
+		if err != nil {
+			writeError(writer, imageMounterErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]string{"type": imageType, "status": "Mounted"})
+
+	case request.Method == http.MethodDelete && path == "":
+		mountPath := query.Get("path")
+		if mountPath == "" {
+			mountPath = DeveloperImageMountPath
+		}
+
+		if err := client.UnmountImage(request.Context(), mountPath); err != nil {
+			writeError(writer, imageMounterErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]string{"path": mountPath, "status": "Unmounted"})
+	}
+}
+
+// imagesRequestAllowed reports whether handleImages serves method on path,
+// checked before a service is started for the request.
+func imagesRequestAllowed(method string, path string) bool {
+	switch method {
+	case http.MethodGet:
+		return path == "" || path == "lookup"
+	case http.MethodPost:
+		return path == "mount"
+	case http.MethodDelete:
+		return path == ""
+	}
+	return false
+}
+
+func imageMounterErrorStatus(err error) int {
+	var mounterErr *ImageMounterError
+	switch {
+	case errors.Is(err, ErrImageAlreadyMounted):
+		return http.StatusConflict
+	case errors.Is(err, os.ErrNotExist):
+		return http.StatusNotFound
+	case errors.As(err, &mounterErr):
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
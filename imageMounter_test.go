@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleImagesMethod(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPut, ""},
+		{http.MethodPost, ""},
+		{http.MethodDelete, "lookup"},
+		{http.MethodGet, "mount"},
+	} {
+		// The device has no connection, so only a rejection before opening the service passes
+		recorder := httptest.NewRecorder()
+		handleImages(&RemoteDevice{serialNumber: "test"}, recorder, httptest.NewRequest(test.method, "/v1/devices/test/images/"+test.path, nil), test.path)
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s images/%s: expected %d, got %d", test.method, test.path, http.StatusMethodNotAllowed, recorder.Code)
+		}
+	}
+}
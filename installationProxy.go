@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const InstallationProxyServiceName = "com.apple.mobile.installation_proxy"
+
+// Staging directory on the media partition IPAs are uploaded to before installing
+const InstallationProxyStagingDirectory = "PublicStaging"
+
+const InstallationProxyStatusComplete = "Complete"
+
+// InstallationProxyError is an Error reply from installation_proxy.
+type InstallationProxyError struct {
+	Command     string
+	Code        string
+	Description string
+}
+
+func (err *InstallationProxyError) Error() string {
+	if err.Description != "" {
+		return fmt.Sprintf("installation_proxy %s failed: %s (%s)", err.Command, err.Code, err.Description)
+	}
+	return fmt.Sprintf("installation_proxy %s failed: %s", err.Command, err.Code)
+}
+
+type InstallationProxyRequest struct {
+	Command               string                 `plist:"Command"`
+	ClientOptions         map[string]interface{} `plist:"ClientOptions,omitempty"`
+	PackagePath           string                 `plist:"PackagePath,omitempty"`
+	ApplicationIdentifier string                 `plist:"ApplicationIdentifier,omitempty"`
+}
+
+type InstallationProxyReply struct {
+	Status           string                   `plist:"Status"`
+	PercentComplete  uint64                   `plist:"PercentComplete"`
+	CurrentList      []map[string]interface{} `plist:"CurrentList"`
+	Error            string                   `plist:"Error"`
+	ErrorDescription string                   `plist:"ErrorDescription"`
+}
+
+type InstallationProxyClient struct {
+	service *ServiceConnection
+}
+
+func (device *RemoteDevice) OpenInstallationProxy(ctx context.Context) (*InstallationProxyClient, error) {
+	service, err := device.OpenService(ctx, InstallationProxyServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &InstallationProxyClient{service: service}, nil
+}
+
+func (client *InstallationProxyClient) Close() error {
+	return client.service.Close()
+}
+
+// run sends request and reads replies until the command completes, calling
+// reply for every intermediate message.
+func (client *InstallationProxyClient) run(ctx context.Context, request *InstallationProxyRequest, reply func(*InstallationProxyReply)) error {
+	if err := client.service.Send(ctx, request); err != nil {
+		return err
+	}
+
+	for {
+		message := &InstallationProxyReply{}
+		if err := client.service.Receive(ctx, message); err != nil {
+			return err
+		}
+
+		if message.Error != "" {
+			return &InstallationProxyError{Command: request.Command, Code: message.Error, Description: message.ErrorDescription}
+		}
+		if reply != nil {
+			reply(message)
+		}
+		if message.Status == InstallationProxyStatusComplete {
+			return nil
+		}
+	}
+}
+
+// Browse lists installed applications of applicationType ("User", "System" or
+// "Any"), returning only attributes when any are given.
+func (client *InstallationProxyClient) Browse(ctx context.Context, applicationType string, attributes []string) ([]map[string]interface{}, error) {
+	options := map[string]interface{}{}
+	if applicationType != "" {
+		options["ApplicationType"] = applicationType
+	}
+	if len(attributes) > 0 {
+		options["ReturnAttributes"] = attributes
+	}
+
+	var applications []map[string]interface{}
+	err := client.run(ctx, &InstallationProxyRequest{Command: "Browse", ClientOptions: options}, func(reply *InstallationProxyReply) {
+		applications = append(applications, reply.CurrentList...)
+	})
+	return applications, err
+}
+
+// Install installs the package at packagePath on the media partition, usually
+// below InstallationProxyStagingDirectory.
+func (client *InstallationProxyClient) Install(ctx context.Context, packagePath string, options map[string]interface{}, progress func(*OperationProgress)) error {
+	request := &InstallationProxyRequest{Command: "Install", PackagePath: packagePath, ClientOptions: options}
+	return client.run(ctx, request, progressReporter(request.Command, packagePath, progress))
+}
+
+func (client *InstallationProxyClient) Uninstall(ctx context.Context, bundleID string, progress func(*OperationProgress)) error {
+	request := &InstallationProxyRequest{Command: "Uninstall", ApplicationIdentifier: bundleID}
+	return client.run(ctx, request, progressReporter(request.Command, bundleID, progress))
+}
+
+func progressReporter(command string, target string, progress func(*OperationProgress)) func(*InstallationProxyReply) {
+	return func(reply *InstallationProxyReply) {
+		if progress == nil {
+			return
+		}
+
+		percent := reply.PercentComplete
+		if reply.Status == InstallationProxyStatusComplete {
+			percent = 100
+		}
+		progress(&OperationProgress{Command: command, Target: target, Status: reply.Status, PercentComplete: percent})
+	}
+}
+
+// handleApps serves the installation_proxy endpoints:
+//
+//	GET    /v1/devices/{id}/apps?type=User&attributes=CFBundleIdentifier,CFBundleVersion
+//	POST   /v1/devices/{id}/apps/install?package=PublicStaging/App.ipa
+//	DELETE /v1/devices/{id}/apps/{bundleID}
+//
+// Install and uninstall stream their progress as newline delimited JSON and
+// publish it as device events.
+func handleApps(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	if !appsRequestAllowed(request.Method, path) {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on apps/%s", request.Method, path))
+		return
+	}
+
+	client, err := device.OpenInstallationProxy(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	query := request.URL.Query()
+
+	switch {
+	case request.Method == http.MethodGet && path == "":
+		var attributes []string
+		if query.Get("attributes") != "" {
+			attributes = strings.Split(query.Get("attributes"), ",")
+		}
+
+		applications, err := client.Browse(request.Context(), query.Get("type"), attributes)
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, applications)
+
+	case request.Method == http.MethodPost && path == "install":
+		packagePath := query.Get("package")
+		if packagePath == "" {
+			writeError(writer, http.StatusBadRequest, fmt.Errorf("missing package parameter"))
+			return
+		}
+
+		streamProgress(device, writer, DeviceEventInstallProgress, func(progress func(*OperationProgress)) error {
+			return client.Install(request.Context(), packagePath, nil, progress)
+		})
+
+	case request.Method == http.MethodDelete && path != "":
+		streamProgress(device, writer, DeviceEventInstallProgress, func(progress func(*OperationProgress)) error {
+			return client.Uninstall(request.Context(), path, progress)
+		})
+	}
+}
+
+// appsRequestAllowed reports whether handleApps serves method on path, checked
+// before a service is started for the request.
+func appsRequestAllowed(method string, path string) bool {
+	switch method {
+	case http.MethodGet:
+		return path == ""
+	case http.MethodPost:
+		return path == "install"
+	case http.MethodDelete:
+		return path != ""
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleAppsMethod(t *testing.T) {
+	for _, request := range []*http.Request{
+		httptest.NewRequest(http.MethodPut, "/v1/devices/test/apps", nil),
+		httptest.NewRequest(http.MethodPost, "/v1/devices/test/apps", nil),
+		httptest.NewRequest(http.MethodDelete, "/v1/devices/test/apps", nil),
+	} {
+		// The device has no connection, so only a rejection before opening the service passes
+		recorder := httptest.NewRecorder()
+		handleApps(&RemoteDevice{serialNumber: "test"}, recorder, request, "")
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s: expected %d, got %d", request.Method, http.StatusMethodNotAllowed, recorder.Code)
+		}
+	}
+}
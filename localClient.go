@@ -48,6 +48,7 @@ type LocalClientTCPHandler struct {
 	connectHeader *USBMuxDHeader
 	device        *RemoteDevice
 	channel       *TCPChannel
+	connected     bool
 }
 
 type USBMuxDHeader struct {
@@ -158,6 +159,7 @@ func (client *LocalClient) readPump() {
 		count, err := io.ReadFull(client.reader, headerData)
 		if err == io.EOF {
 			client.open = false
+			client.closeChannels()
 			client.close <- true
 			break
 		}
@@ -196,6 +198,7 @@ func (client *LocalClient) readPump() {
 			count, err = io.ReadFull(client.reader, plistBytes)
 			if err == io.EOF {
 				client.open = false
+				client.closeChannels()
 				client.close <- true
 				break
 			}
@@ -248,11 +251,22 @@ func (handler *LocalClientTCPHandler) connectionStateChange(state int) {
 	fmt.Printf("LocalClientTCPHandler connectionStateChanged %d\n", state)
 	switch state {
 	case TCPStateConnected:
+		handler.connected = true
 		result := &ResultMessage{
 			MessageType: MessageTypeListResult,
 			Number:      USBMuxDResultOK,
 		}
 		handler.localClient.sendPlistResponse(*handler.connectHeader, result)
+	case TCPStateRefused:
+		// After the connect result the local socket carries raw data only
+		if handler.connected {
+			return
+		}
+		result := &ResultMessage{
+			MessageType: MessageTypeListResult,
+			Number:      USBMuxDResultConnectionRefused,
+		}
+		handler.localClient.sendPlistResponse(*handler.connectHeader, result)
 	}
 }
 
@@ -284,6 +298,15 @@ func (client *LocalClient) handlePlistMessage(header USBMuxDHeader, dictionary m
 		}
 
 		handler.channel = device.createTCPChannel(uint16(port), handler)
+		if handler.channel == nil {
+			result := &ResultMessage{
+				MessageType: MessageTypeListResult,
+				Number:      USBMuxDResultConnectionRefused,
+			}
+			client.sendPlistResponse(header, result)
+		} else {
+			client.channels = append(client.channels, handler)
+		}
 
 	case MessageTypeListDevices:
 		deviceList := &ListDevicesMessage{
@@ -312,6 +335,15 @@ func (client *LocalClient) handlePlistMessage(header USBMuxDHeader, dictionary m
 	}
 }
 
+// closeChannels shuts down the device side of every connection this client
+// opened, so they don't outlive the local socket.
+func (client *LocalClient) closeChannels() {
+	for _, handler := range client.channels {
+		handler.channel.Close()
+	}
+	client.channels = nil
+}
+
 func (client *LocalClient) ensureDeviceMap() {
 	if client.deviceMap == nil {
 		client.deviceMap = make(map[uint32]*RemoteDevice)
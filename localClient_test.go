@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/binary"
+	"gopkg.in/restruct.v1"
+	"howett.net/plist"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestConnectTimeoutRefused(t *testing.T) {
+	device, writer, clock := newTestDevice()
+	device.tcpConfig.connectTimeout = 5 * time.Second
+
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+
+	client := makeClient(nil, &local)
+	client.deviceMap = map[uint32]*RemoteDevice{1: device}
+
+	header := USBMuxDHeader{Version: 1, Message: USBMuxDMessagePlist, Tag: 7}
+	client.handlePlistMessage(header, map[string]interface{}{
+		"MessageType": MessageTypeConnect,
+		"DeviceID":    uint64(1),
+		"PortNumber":  uint64(62078),
+	})
+	if len(writer.packets) != 1 || !writer.packets[0].header.hasFlag(TCPHeaderFlagSYN) {
+		t.Fatalf("expected a SYN, got %d packets", len(writer.packets))
+	}
+
+	// The device never answers
+	results := make(chan *ResultMessage, 1)
+	go func() {
+		data := make([]byte, USBMuxDHeaderSize)
+		if _, err := io.ReadFull(remote, data); err != nil {
+			close(results)
+			return
+		}
+		response := USBMuxDHeader{}
+		restruct.Unpack(data, binary.LittleEndian, &response)
+
+		data = make([]byte, response.Length-USBMuxDHeaderSize)
+		if _, err := io.ReadFull(remote, data); err != nil || response.Tag != header.Tag {
+			close(results)
+			return
+		}
+		result := &ResultMessage{}
+		plist.Unmarshal(data, result)
+		results <- result
+	}()
+
+	clock.Advance(5 * time.Second)
+
+	result, ok := <-results
+	if !ok {
+		t.Fatalf("no result for the connect request")
+	}
+	if result.Number != USBMuxDResultConnectionRefused {
+		t.Fatalf("expected result %d, got %d", USBMuxDResultConnectionRefused, result.Number)
+	}
+
+	last := writer.packets[len(writer.packets)-1]
+	if !last.header.hasFlag(TCPHeaderFlagRST) {
+		t.Fatalf("expected the half open channel to be reset")
+	}
+	if len(device.channels) != 0 {
+		t.Fatalf("expected the refused channel to release its port")
+	}
+}
@@ -1,38 +1,350 @@
 package main
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
 const LockdownPort = 0xf27e
 
-type LockdownQueryTypeMessage struct {
+const LockdownLabel = "webmuxd"
+
+const LockdownProtocolVersion = "2"
+
+// Error codes returned by lockdownd in the Error key of a reply
+const (
+	LockdownErrorInvalidHostID     = "InvalidHostID"
+	LockdownErrorPasswordProtected = "PasswordProtected"
+	LockdownErrorUserDeniedPairing = "UserDeniedPairing"
+	LockdownErrorPairingDialog     = "PairingDialogResponsePending"
+	LockdownErrorInvalidService    = "InvalidService"
+	LockdownErrorSessionInactive   = "SessionInactive"
+	LockdownErrorMissingValue      = "MissingValue"
+)
+
+var ErrLockdownClosed = errors.New("lockdown connection closed")
+
+// LockdownError is a lockdownd reply carrying an Error. It matches other
+// LockdownErrors with the same code under errors.Is.
+type LockdownError struct {
 	Request string
-	Label   string
+	Code    string
 }
 
-type LockdownGetValueMessage struct {
-	Label   string
-	Request string
+func (err *LockdownError) Error() string {
+	return fmt.Sprintf("lockdown %s failed: %s", err.Request, err.Code)
+}
+
+func (err *LockdownError) Is(target error) bool {
+	other, ok := target.(*LockdownError)
+	return ok && other.Code == err.Code
+}
+
+// LockdownRequest is the envelope of every request sent to lockdownd. Only the
+// fields a request uses are set.
+type LockdownRequest struct {
+	Label           string      `plist:"Label"`
+	Request         string      `plist:"Request"`
+	ProtocolVersion string      `plist:"ProtocolVersion,omitempty"`
+	Domain          string      `plist:"Domain,omitempty"`
+	Key             string      `plist:"Key,omitempty"`
+	Value           interface{} `plist:"Value,omitempty"`
+	HostID          string      `plist:"HostID,omitempty"`
+	SystemBUID      string      `plist:"SystemBUID,omitempty"`
+	SessionID       string      `plist:"SessionID,omitempty"`
+	Service         string      `plist:"Service,omitempty"`
+	EscrowBag       []byte      `plist:"EscrowBag,omitempty"`
+
+	PairRecord     *LockdownPairRecord     `plist:"PairRecord,omitempty"`
+	PairingOptions *LockdownPairingOptions `plist:"PairingOptions,omitempty"`
+}
+
+// LockdownServiceInfo is lockdownd's answer to StartService.
+type LockdownServiceInfo struct {
+	Service          string
+	Port             uint16
+	EnableServiceSSL bool
+}
+
+type lockdownReply struct {
+	data map[string]interface{}
+	err  error
 }
 
 type LockdownService struct {
 	propertyListService *PropertyListService
+
+	// Serialises calls, lockdownd answers requests strictly in order
+	callMutex sync.Mutex
+
+	mutex sync.Mutex
+
+	// Closed once the connection is up
+	ready     chan struct{}
+	readyOnce sync.Once
+
+	// Closed when the connection ended, err says why
+	done     chan struct{}
+	doneOnce sync.Once
+	err      error
+
+	// Reply channel of the request in flight
+	pending     chan lockdownReply
+	pendingName string
+
+	// Replies still due for calls abandoned through their context
+	discard int
+
+	sessionID  string
+	sessionSSL bool
 }
 
 func (service *LockdownService) connected() {
-	handshake := &LockdownQueryTypeMessage{
-		Request: "QueryType",
-		Label:   "webserver",
+	service.readyOnce.Do(func() {
+		close(service.ready)
+	})
+}
+
+func (service *LockdownService) plistReceived(value interface{}) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		fmt.Printf("LockdownService ignoring %T message\n", value)
+		return
+	}
+
+	service.mutex.Lock()
+	if service.discard > 0 {
+		service.discard--
+		service.mutex.Unlock()
+		fmt.Printf("LockdownService dropping reply to abandoned %s\n", data["Request"])
+		return
+	}
+
+	pending := service.pending
+	name := service.pendingName
+	service.pending = nil
+	service.mutex.Unlock()
+
+	if pending == nil {
+		fmt.Printf("LockdownService unsolicited message %s\n", data["Request"])
+		return
 	}
-	service.propertyListService.sendPropertyList(handshake)
+
+	if request, ok := data["Request"].(string); ok && request != name {
+		fmt.Printf("LockdownService reply for %s while waiting for %s\n", request, name)
+	}
+
+	pending <- lockdownReply{data: data}
 }
 
-func (service *LockdownService) plistReceived(data map[string]interface{}) {
-	if data["Request"] == "QueryType" {
-		getValueMessage := &LockdownGetValueMessage{
-			Request: "GetValue",
-			Label:   "webmuxd",
+func (service *LockdownService) plistError(err error) {
+	fmt.Printf("LockdownService error %s\n", err)
+
+	if errors.Is(err, ErrPropertyListServiceClosed) {
+		service.close(ErrLockdownClosed)
+	}
+
+	service.mutex.Lock()
+	pending := service.pending
+	service.pending = nil
+	if pending == nil && service.discard > 0 {
+		service.discard--
+	}
+	service.mutex.Unlock()
+
+	if pending != nil {
+		pending <- lockdownReply{err: err}
+	}
+}
+
+// close marks the connection as finished, failing calls waiting on it.
+func (service *LockdownService) close(err error) {
+	service.doneOnce.Do(func() {
+		service.mutex.Lock()
+		service.err = err
+		service.mutex.Unlock()
+		close(service.done)
+	})
+}
+
+// closed reports whether the connection ended.
+func (service *LockdownService) closed() bool {
+	select {
+	case <-service.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// call sends request and waits for lockdownd's reply, turning an Error in the
+// reply into a *LockdownError.
+func (service *LockdownService) call(ctx context.Context, request *LockdownRequest) (map[string]interface{}, error) {
+	request.Label = LockdownLabel
+
+	select {
+	case <-service.ready:
+	case <-service.done:
+		return nil, service.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	service.callMutex.Lock()
+	defer service.callMutex.Unlock()
+
+	reply := make(chan lockdownReply, 1)
+
+	service.mutex.Lock()
+	if service.closed() {
+		service.mutex.Unlock()
+		return nil, service.err
+	}
+	service.pending = reply
+	service.pendingName = request.Request
+	service.mutex.Unlock()
+
+	service.propertyListService.sendPropertyList(request)
+
+	select {
+	case result := <-reply:
+		if result.err != nil {
+			return nil, result.err
+		}
+		if code, ok := result.data["Error"].(string); ok {
+			return result.data, &LockdownError{Request: request.Request, Code: code}
+		}
+		return result.data, nil
+
+	case <-service.done:
+		return nil, service.err
+
+	case <-ctx.Done():
+		service.mutex.Lock()
+		if service.pending == reply {
+			// The reply is still coming and must not be mistaken for the next one
+			service.pending = nil
+			service.discard++
 		}
+		service.mutex.Unlock()
+		return nil, ctx.Err()
+	}
+}
+
+// QueryType returns the type of the service listening on the lockdown port,
+// "com.apple.mobile.lockdown" on a normally booted device.
+func (service *LockdownService) QueryType(ctx context.Context) (string, error) {
+	reply, err := service.call(ctx, &LockdownRequest{Request: "QueryType"})
+	if err != nil {
+		return "", err
+	}
+
+	serviceType, _ := reply["Type"].(string)
+	return serviceType, nil
+}
+
+// GetValue reads key from domain. An empty key returns the whole domain as a
+// dictionary, an empty domain refers to the global domain.
+func (service *LockdownService) GetValue(ctx context.Context, domain string, key string) (interface{}, error) {
+	reply, err := service.call(ctx, &LockdownRequest{Request: "GetValue", Domain: domain, Key: key})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply["Value"], nil
+}
+
+func (service *LockdownService) SetValue(ctx context.Context, domain string, key string, value interface{}) error {
+	_, err := service.call(ctx, &LockdownRequest{Request: "SetValue", Domain: domain, Key: key, Value: value})
+	return err
+}
+
+func (service *LockdownService) RemoveValue(ctx context.Context, domain string, key string) error {
+	_, err := service.call(ctx, &LockdownRequest{Request: "RemoveValue", Domain: domain, Key: key})
+	return err
+}
+
+// StartSession authenticates as the host in record and switches the
+// connection to TLS when lockdownd asks for it.
+func (service *LockdownService) StartSession(ctx context.Context, record *PairRecord) error {
+	reply, err := service.call(ctx, &LockdownRequest{
+		Request:    "StartSession",
+		HostID:     record.HostID,
+		SystemBUID: record.SystemBUID,
+	})
+	if err != nil {
+		return err
+	}
+
+	sessionID, _ := reply["SessionID"].(string)
+	enableSSL, _ := reply["EnableSessionSSL"].(bool)
+
+	service.mutex.Lock()
+	service.sessionID = sessionID
+	service.sessionSSL = enableSSL
+	service.mutex.Unlock()
 
-		service.propertyListService.sendPropertyList(getValueMessage)
+	if !enableSSL {
+		return nil
 	}
+
+	config, err := record.tlsConfig()
+	if err != nil {
+		return err
+	}
+
+	return service.propertyListService.startTLS(ctx, config, false)
+}
+
+// StopSession ends the current session. lockdownd drops back to plain text
+// afterwards, which an encrypted stream can't follow, so a session that used
+// TLS also ends the connection; RemoteDevice.lockdown opens a fresh one.
+func (service *LockdownService) StopSession(ctx context.Context) error {
+	service.mutex.Lock()
+	sessionID := service.sessionID
+	sessionSSL := service.sessionSSL
+	service.mutex.Unlock()
+
+	if sessionID == "" {
+		return &LockdownError{Request: "StopSession", Code: LockdownErrorSessionInactive}
+	}
+
+	_, err := service.call(ctx, &LockdownRequest{Request: "StopSession", SessionID: sessionID})
+
+	service.mutex.Lock()
+	service.sessionID = ""
+	service.sessionSSL = false
+	service.mutex.Unlock()
+
+	if sessionSSL {
+		service.close(ErrLockdownClosed)
+		service.propertyListService.channel.Close()
+	}
+
+	return err
+}
+
+// StartService asks lockdownd to launch name and returns where it listens. It
+// needs an active session.
+func (service *LockdownService) StartService(ctx context.Context, name string, escrowBag []byte) (*LockdownServiceInfo, error) {
+	reply, err := service.call(ctx, &LockdownRequest{Request: "StartService", Service: name, EscrowBag: escrowBag})
+	if err != nil {
+		return nil, err
+	}
+
+	port, ok := reply["Port"].(uint64)
+	if !ok {
+		return nil, &LockdownError{Request: "StartService", Code: LockdownErrorMissingValue}
+	}
+	enableSSL, _ := reply["EnableServiceSSL"].(bool)
+
+	return &LockdownServiceInfo{
+		Service:          name,
+		Port:             uint16(port),
+		EnableServiceSSL: enableSSL,
+	}, nil
 }
 
 func (device *RemoteDevice) createLockdownService() *LockdownService {
@@ -41,9 +353,28 @@ func (device *RemoteDevice) createLockdownService() *LockdownService {
 		encrypted: false,
 	}
 
-	service := &LockdownService{}
+	service := &LockdownService{
+		ready: make(chan struct{}),
+		done:  make(chan struct{}),
+	}
 
 	service.propertyListService = device.createService(serviceDescriptor, service)
+	if service.propertyListService.channel == nil {
+		service.close(ErrDeviceNoPorts)
+	}
 
 	return service
 }
+
+// lockdown returns the device's lockdown client, reconnecting when the previous
+// connection ended.
+func (device *RemoteDevice) lockdown() *LockdownService {
+	device.lockdownMutex.Lock()
+	defer device.lockdownMutex.Unlock()
+
+	if device.LockdownService == nil || device.LockdownService.closed() {
+		device.LockdownService = device.createLockdownService()
+	}
+
+	return device.LockdownService
+}
@@ -7,11 +7,21 @@ import (
 	"net"
 	"net/http"
 	"os"
+	"time"
 )
 
 var socketFile = flag.String("socket", "/tmp/remote_usbmuxd.sock", "local unix socket")
 var addressFlag = flag.String("listen", "127.0.0.1", "remote service address")
 var portFlag = flag.Int("port", 8080, "remote service port")
+var segmentSizeFlag = flag.Int("segment-size", MUXMaxFrameSize-USBMuxDHeaderSize-TCPHeaderSize, "maximum TCP payload per MUX frame")
+var usbPacketSizeFlag = flag.Int("usb-packet-size", 512, "device bulk endpoint max packet size, unless the client sends USB descriptors")
+var pairRecordsFlag = flag.String("pair-records", "/var/lib/lockdown", "directory holding device pair records")
+var backupsFlag = flag.String("backups", "/var/lib/webmuxd/backups", "directory holding mobilebackup2 backups")
+var imagesFlag = flag.String("images", "/var/lib/webmuxd/images", "directory holding developer disk images")
+var crashReportsFlag = flag.String("crash-reports", "/var/lib/webmuxd/crashreports", "directory crash reports are pulled into")
+var connectTimeoutFlag = flag.Duration("connect-timeout", 10*time.Second, "time to wait for a device port to accept a connection")
+var keepAliveFlag = flag.Duration("keepalive", 0, "probe device connections idle for this long (0 disables)")
+var idleTimeoutFlag = flag.Duration("idle-timeout", 0, "abort device connections without traffic for this long (0 disables)")
 
 func main() {
 	flag.Parse()
@@ -27,12 +37,14 @@ func main() {
 	defer localSocket.Close()
 	fmt.Printf("Local socket opened at %s\n", *socketFile)
 
-	hub := newHub(&localSocket)
+	hub := newHub(&localSocket, &PairRecordStore{directory: *pairRecordsFlag})
 
 	go hub.runLocalConnections()
 
 	go hub.run()
 
+	hub.registerManagementHandlers()
+
 	http.HandleFunc("/v1/device", func(writer http.ResponseWriter, reader *http.Request) {
 		hub.handleRemoteConnection(writer, reader)
 	})
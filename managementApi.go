@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	DeviceEventAttached = "Attached"
+	DeviceEventRemoved  = "Removed"
+	DeviceEventInfo     = "Info"
+
+	DeviceEventInstallProgress = "InstallProgress"
+	DeviceEventBackupProgress  = "BackupProgress"
+	DeviceEventNotification    = "Notification"
+)
+
+// Events buffered per subscriber before further events are dropped for it
+const eventSubscriberBuffer = 64
+
+// DeviceEvent is published to management API subscribers.
+type DeviceEvent struct {
+	Type   string      `json:"type"`
+	Device string      `json:"device"`
+	Info   *DeviceInfo `json:"info,omitempty"`
+	Data   interface{} `json:"data,omitempty"`
+}
+
+// EventSubscriber receives device events from the hub.
+type EventSubscriber struct {
+	send chan *DeviceEvent
+}
+
+// DeviceRouteHandler serves /v1/devices/{id}/{route}/{path}.
+type DeviceRouteHandler func(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string)
+
+// deviceRoutes maps the first path segment after the device id to its handler.
+var deviceRoutes = map[string]DeviceRouteHandler{
+	"syslog":        handleSyslog,
+	"files":         handleFiles,
+	"containers":    handleContainers,
+	"documents":     handleDocuments,
+	"apps":          handleApps,
+	"screenshot":    handleScreenshot,
+	"backup":        handleBackup,
+	"diagnostics":   handleDiagnostics,
+	"notifications": handleNotifications,
+	"images":        handleImages,
+	"crashes":       handleCrashReports,
+	"profiles":      handleProfiles,
+	"springboard":   handleSpringBoard,
+	"pairing":       handlePairing,
+}
+
+func (hub *Hub) registerManagementHandlers() {
+	http.HandleFunc("/v1/devices", hub.handleDeviceList)
+	http.HandleFunc("/v1/devices/", hub.handleDevice)
+	http.HandleFunc("/v1/events", hub.handleEvents)
+}
+
+func writeJSON(writer http.ResponseWriter, status int, value interface{}) {
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(status)
+	if err := json.NewEncoder(writer).Encode(value); err != nil {
+		fmt.Printf("Management API encode error %s\n", err)
+	}
+}
+
+func writeError(writer http.ResponseWriter, status int, err error) {
+	writeJSON(writer, status, map[string]string{"error": err.Error()})
+}
+
+// findDevice looks a device up by USB serial number or UDID.
+func (hub *Hub) findDevice(id string) *RemoteDevice {
+	hub.devicesMutex.RLock()
+	defer hub.devicesMutex.RUnlock()
+
+	if device := hub.devices[id]; device != nil {
+		return device
+	}
+	for _, device := range hub.devices {
+		if device != nil && device.udid() == id {
+			return device
+		}
+	}
+	return nil
+}
+
+func (hub *Hub) handleDeviceList(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed", request.Method))
+		return
+	}
+
+	hub.devicesMutex.RLock()
+	devices := make([]DeviceInfo, 0, len(hub.devices))
+	for _, device := range hub.devices {
+		if device != nil {
+			devices = append(devices, device.getInfo())
+		}
+	}
+	hub.devicesMutex.RUnlock()
+
+	writeJSON(writer, http.StatusOK, devices)
+}
+
+// handleDevice serves /v1/devices/{id} and hands longer paths to deviceRoutes.
+func (hub *Hub) handleDevice(writer http.ResponseWriter, request *http.Request) {
+	path := strings.TrimPrefix(request.URL.Path, "/v1/devices/")
+	parts := strings.SplitN(path, "/", 3)
+
+	device := hub.findDevice(parts[0])
+	if device == nil {
+		writeError(writer, http.StatusNotFound, fmt.Errorf("device %s not found", parts[0]))
+		return
+	}
+
+	if len(parts) == 1 || parts[1] == "" {
+		info := device.getInfo()
+		writeJSON(writer, http.StatusOK, &info)
+		return
+	}
+
+	route, ok := deviceRoutes[parts[1]]
+	if !ok {
+		writeError(writer, http.StatusNotFound, fmt.Errorf("unknown device endpoint %s", parts[1]))
+		return
+	}
+
+	remainder := ""
+	if len(parts) == 3 {
+		remainder = parts[2]
+	}
+	route(device, writer, request, remainder)
+}
+
+// handleEvents streams device events over a websocket as JSON messages.
+func (hub *Hub) handleEvents(writer http.ResponseWriter, request *http.Request) {
+	connection, err := hub.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		fmt.Printf("Management API events upgrade error %s\n", err)
+		return
+	}
+	defer connection.Close()
+
+	subscriber := &EventSubscriber{send: make(chan *DeviceEvent, eventSubscriberBuffer)}
+	hub.subscribe <- subscriber
+	defer func() {
+		hub.unsubscribe <- subscriber
+	}()
+
+	// Reading is only needed to notice the client going away
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event, ok := <-subscriber.send:
+			if !ok {
+				return
+			}
+			connection.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := connection.WriteJSON(event); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// publishEvent hands event to every subscriber without waiting on slow ones.
+// It runs on the hub goroutine.
+func (hub *Hub) publishEvent(event *DeviceEvent) {
+	for subscriber := range hub.subscribers {
+		select {
+		case subscriber.send <- event:
+		default:
+			fmt.Printf("Management API dropping %s event for slow subscriber\n", event.Type)
+		}
+	}
+}
+
+// OperationProgress is reported by long running device operations such as
+// installs and backups.
+type OperationProgress struct {
+	Command         string `json:"command"`
+	Target          string `json:"target"`
+	Status          string `json:"status"`
+	PercentComplete uint64 `json:"percentComplete"`
+	Error           string `json:"error,omitempty"`
+}
+
+// streamProgress runs operation, writing every progress update as a JSON line
+// and publishing it as a device event of eventType. A failure ends the stream
+// with a line carrying the error.
+func streamProgress(device *RemoteDevice, writer http.ResponseWriter, eventType string, operation func(func(*OperationProgress)) error) {
+	writer.Header().Set("Content-Type", "application/x-ndjson")
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+	encoder := json.NewEncoder(writer)
+
+	last := &OperationProgress{}
+	report := func(progress *OperationProgress) {
+		last = progress
+		encoder.Encode(progress)
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		device.hub.events <- &DeviceEvent{Type: eventType, Device: device.serialNumber, Data: progress}
+	}
+
+	if err := operation(report); err != nil {
+		report(&OperationProgress{Command: last.Command, Target: last.Target, Status: "Failed", PercentComplete: last.PercentComplete, Error: err.Error()})
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"howett.net/plist"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const MisagentServiceName = "com.apple.misagent"
+
+const MisagentProfileType = "Provisioning"
+
+// Largest profile accepted for installation
+const misagentMaxProfileSize = 1024 * 1024
+
+// MisagentError is a misagent reply with a non zero Status.
+type MisagentError struct {
+	Command string
+	Status  uint64
+}
+
+func (err *MisagentError) Error() string {
+	return fmt.Sprintf("misagent %s failed: status 0x%x", err.Command, err.Status)
+}
+
+type MisagentRequest struct {
+	MessageType string `plist:"MessageType"`
+	ProfileType string `plist:"ProfileType"`
+	Profile     []byte `plist:"Profile,omitempty"`
+	ProfileID   string `plist:"ProfileID,omitempty"`
+}
+
+type MisagentReply struct {
+	Status  uint64   `plist:"Status"`
+	Payload [][]byte `plist:"Payload"`
+}
+
+// ProvisioningProfile is the metadata of a provisioning profile, taken from
+// the plist signed inside its CMS envelope.
+type ProvisioningProfile struct {
+	Name                 string                 `plist:"Name" json:"name"`
+	UUID                 string                 `plist:"UUID" json:"uuid"`
+	AppIDName            string                 `plist:"AppIDName" json:"appIdName"`
+	TeamName             string                 `plist:"TeamName" json:"teamName"`
+	TeamIdentifier       []string               `plist:"TeamIdentifier" json:"teamIdentifier"`
+	CreationDate         time.Time              `plist:"CreationDate" json:"creationDate"`
+	ExpirationDate       time.Time              `plist:"ExpirationDate" json:"expirationDate"`
+	ProvisionsAllDevices bool                   `plist:"ProvisionsAllDevices" json:"provisionsAllDevices,omitempty"`
+	ProvisionedDevices   []string               `plist:"ProvisionedDevices" json:"provisionedDevices,omitempty"`
+	Entitlements         map[string]interface{} `plist:"Entitlements" json:"-"`
+
+	// application-identifier entitlement, team prefix included
+	AppID   string `plist:"-" json:"appId"`
+	Expired bool   `plist:"-" json:"expired"`
+}
+
+// ASN.1 structure of the CMS envelope, only as far as needed to reach the content
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo cmsEncapsulatedContentInfo
+	Rest             asn1.RawValue `asn1:"optional"`
+}
+
+type cmsEncapsulatedContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type MisagentClient struct {
+	service *ServiceConnection
+}
+
+func (device *RemoteDevice) OpenMisagent(ctx context.Context) (*MisagentClient, error) {
+	service, err := device.OpenService(ctx, MisagentServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &MisagentClient{service: service}, nil
+}
+
+func (client *MisagentClient) Close() error {
+	return client.service.Close()
+}
+
+func (client *MisagentClient) call(ctx context.Context, request *MisagentRequest) (*MisagentReply, error) {
+	request.ProfileType = MisagentProfileType
+
+	reply := &MisagentReply{}
+	if err := client.service.Call(ctx, request, reply); err != nil {
+		return nil, err
+	}
+	if reply.Status != 0 {
+		return nil, &MisagentError{Command: request.MessageType, Status: reply.Status}
+	}
+
+	return reply, nil
+}
+
+// CopyAll returns the installed profiles in their signed form.
+func (client *MisagentClient) CopyAll(ctx context.Context) ([][]byte, error) {
+	reply, err := client.call(ctx, &MisagentRequest{MessageType: "CopyAll"})
+	if err != nil {
+		return nil, err
+	}
+
+	return reply.Payload, nil
+}
+
+// Profiles returns the decoded metadata of the installed profiles.
+func (client *MisagentClient) Profiles(ctx context.Context) ([]*ProvisioningProfile, error) {
+	payloads, err := client.CopyAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make([]*ProvisioningProfile, 0, len(payloads))
+	for _, payload := range payloads {
+		profile, err := decodeProvisioningProfile(payload)
+		if err != nil {
+			fmt.Printf("Misagent skipping undecodable profile: %s\n", err)
+			continue
+		}
+		profiles = append(profiles, profile)
+	}
+
+	return profiles, nil
+}
+
+// Install installs a signed profile as read from a .mobileprovision file.
+func (client *MisagentClient) Install(ctx context.Context, profile []byte) error {
+	_, err := client.call(ctx, &MisagentRequest{MessageType: "Install", Profile: profile})
+	return err
+}
+
+func (client *MisagentClient) Remove(ctx context.Context, uuid string) error {
+	_, err := client.call(ctx, &MisagentRequest{MessageType: "Remove", ProfileID: uuid})
+	return err
+}
+
+// decodeProvisioningProfile extracts the plist a profile's CMS envelope signs.
+// The signature itself is not verified.
+func decodeProvisioningProfile(data []byte) (*ProvisioningProfile, error) {
+	contentInfo := cmsContentInfo{}
+	if _, err := asn1.Unmarshal(data, &contentInfo); err != nil {
+		return nil, fmt.Errorf("profile envelope: %w", err)
+	}
+
+	signedData := cmsSignedData{}
+	if _, err := asn1.Unmarshal(contentInfo.Content.Bytes, &signedData); err != nil {
+		return nil, fmt.Errorf("profile signed data: %w", err)
+	}
+
+	var content []byte
+	if _, err := asn1.Unmarshal(signedData.EncapContentInfo.Content.Bytes, &content); err != nil {
+		return nil, fmt.Errorf("profile content: %w", err)
+	}
+
+	profile := &ProvisioningProfile{}
+	if _, err := plist.Unmarshal(content, profile); err != nil {
+		return nil, fmt.Errorf("profile plist: %w", err)
+	}
+
+	profile.AppID, _ = profile.Entitlements["application-identifier"].(string)
+	profile.Expired = !profile.ExpirationDate.IsZero() && time.Now().After(profile.ExpirationDate)
+
+	return profile, nil
+}
+
+// handleProfiles serves the misagent endpoints:
+//
+//	GET    /v1/devices/{id}/profiles
+//	PUT    /v1/devices/{id}/profiles          (body is a .mobileprovision file)
+//	DELETE /v1/devices/{id}/profiles/{uuid}
+func handleProfiles(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	if !profilesRequestAllowed(request.Method, path) {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on profiles/%s", request.Method, path))
+		return
+	}
+
+	var profile []byte
+	if request.Method == http.MethodPut || request.Method == http.MethodPost {
+		data, err := ioutil.ReadAll(http.MaxBytesReader(writer, request.Body, misagentMaxProfileSize))
+		if err != nil {
+			writeError(writer, http.StatusRequestEntityTooLarge, err)
+			return
+		}
+		profile = data
+	}
+
+	client, err := device.OpenMisagent(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	switch {
+	case request.Method == http.MethodGet && path == "":
+		profiles, err := client.Profiles(request.Context())
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, profiles)
+
+	case (request.Method == http.MethodPut || request.Method == http.MethodPost) && path == "":
+		// Reject what the device would refuse anyway with a clearer error
+		decoded, err := decodeProvisioningProfile(profile)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err)
+			return
+		}
+
+		if err = client.Install(request.Context(), profile); err != nil {
+			writeError(writer, misagentErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusCreated, decoded)
+
+	case request.Method == http.MethodDelete && path != "":
+		if err := client.Remove(request.Context(), path); err != nil {
+			writeError(writer, misagentErrorStatus(err), err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]string{"uuid": path, "status": "Removed"})
+	}
+}
+
+// profilesRequestAllowed reports whether handleProfiles serves method on path,
+// checked before the body is read and a service is started for the request.
+func profilesRequestAllowed(method string, path string) bool {
+	switch method {
+	case http.MethodGet, http.MethodPut, http.MethodPost:
+		return path == ""
+	case http.MethodDelete:
+		return path != ""
+	}
+	return false
+}
+
+func misagentErrorStatus(err error) int {
+	var misagentErr *MisagentError
+	if errors.As(err, &misagentErr) {
+		return http.StatusUnprocessableEntity
+	}
+	return http.StatusBadGateway
+}
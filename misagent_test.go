@@ -0,0 +1,26 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHandleProfilesMethod(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		path   string
+	}{
+		{http.MethodPatch, ""},
+		{http.MethodDelete, ""},
+		{http.MethodGet, "8F5E2A4C-0000-0000-0000-000000000000"},
+		{http.MethodPut, "8F5E2A4C-0000-0000-0000-000000000000"},
+	} {
+		// The device has no connection, so only a rejection before opening the service passes
+		recorder := httptest.NewRecorder()
+		handleProfiles(&RemoteDevice{serialNumber: "test"}, recorder, httptest.NewRequest(test.method, "/v1/devices/test/profiles/"+test.path, nil), test.path)
+		if recorder.Code != http.StatusMethodNotAllowed {
+			t.Errorf("%s profiles/%s: expected %d, got %d", test.method, test.path, http.StatusMethodNotAllowed, recorder.Code)
+		}
+	}
+}
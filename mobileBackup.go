@@ -0,0 +1,769 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"howett.net/plist"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const MobileBackupServiceName = "com.apple.mobilebackup2"
+
+// DeviceLink major version mobilebackup2 speaks
+const MobileBackupDeviceLinkVersion = 300
+
+// Versions of the mobilebackup2 protocol offered in the Hello exchange
+var MobileBackupProtocolVersions = []float64{2.0, 2.1}
+
+// Codes prefixing every block of a file transfer
+const (
+	mobileBackupCodeSuccess     = 0x00
+	mobileBackupCodeErrorLocal  = 0x06
+	mobileBackupCodeErrorRemote = 0x0b
+	mobileBackupCodeFileData    = 0x0c
+)
+
+// Largest block of file data sent to the device at once
+const mobileBackupBlockSize = 64 * 1024
+
+// Placeholder DeviceLink uses for an empty string argument
+const deviceLinkEmptyParameter = "___EmptyParameterString___"
+
+// Error codes reported to the device in status responses, as errno values
+// are translated by iTunes
+const (
+	mobileBackupErrorGeneric     = -1
+	mobileBackupErrorNotFound    = -6
+	mobileBackupErrorExists      = -7
+	mobileBackupErrorNotDir      = -8
+	mobileBackupErrorIsDir       = -9
+	mobileBackupErrorIO          = -11
+	mobileBackupErrorNoSpace     = -15
+	mobileBackupErrorMultiStatus = -13
+)
+
+// Position of the overall progress in the DeviceLink messages carrying one
+var mobileBackupProgressIndex = map[string]int{
+	"DLMessageDownloadFiles": 3,
+	"DLMessageUploadFiles":   2,
+	"DLMessageMoveFiles":     3,
+	"DLMessageMoveItems":     3,
+	"DLMessageRemoveFiles":   3,
+	"DLMessageRemoveItems":   3,
+}
+
+// MobileBackupError is a failed backup or restore as reported by the device.
+type MobileBackupError struct {
+	Command     string
+	Code        int64
+	Description string
+}
+
+func (err *MobileBackupError) Error() string {
+	return fmt.Sprintf("mobilebackup2 %s failed: %d (%s)", err.Command, err.Code, err.Description)
+}
+
+// MobileBackupInfo is the Info.plist the host writes next to a backup.
+type MobileBackupInfo struct {
+	BuildVersion     string    `plist:"Build Version"`
+	DeviceName       string    `plist:"Device Name"`
+	DisplayName      string    `plist:"Display Name"`
+	GUID             string    `plist:"GUID"`
+	LastBackupDate   time.Time `plist:"Last Backup Date"`
+	ProductType      string    `plist:"Product Type"`
+	ProductVersion   string    `plist:"Product Version"`
+	SerialNumber     string    `plist:"Serial Number"`
+	TargetIdentifier string    `plist:"Target Identifier"`
+	TargetType       string    `plist:"Target Type"`
+	UniqueIdentifier string    `plist:"Unique Identifier"`
+}
+
+// MobileBackupStatus is the part of the device written Status.plist deciding
+// whether an incremental backup is possible.
+type MobileBackupStatus struct {
+	SnapshotState string `plist:"SnapshotState"`
+	IsFullBackup  bool   `plist:"IsFullBackup"`
+	UUID          string `plist:"UUID"`
+}
+
+// MobileBackupRestoreOptions select what a restore replaces on the device.
+type MobileBackupRestoreOptions struct {
+	SystemFiles       bool
+	Reboot            bool
+	PreserveSettings  bool
+	RemoveNotRestored bool
+}
+
+// MobileBackupClient drives mobilebackup2, serving the device's file requests
+// from a local backup directory laid out like iTunes' (<directory>/<UDID>/...).
+type MobileBackupClient struct {
+	link      *DeviceLinkService
+	device    *RemoteDevice
+	directory string
+}
+
+func (device *RemoteDevice) OpenMobileBackup(ctx context.Context, directory string) (*MobileBackupClient, error) {
+	link, err := device.OpenDeviceLink(ctx, MobileBackupServiceName, MobileBackupDeviceLinkVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &MobileBackupClient{link: link, device: device, directory: directory}
+	if err = client.hello(ctx); err != nil {
+		link.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (client *MobileBackupClient) Close() error {
+	return client.link.Disconnect(context.Background(), deviceLinkEmptyParameter)
+}
+
+func (client *MobileBackupClient) hello(ctx context.Context) error {
+	err := client.link.SendProcessMessage(ctx, map[string]interface{}{
+		"MessageName":               "Hello",
+		"SupportedProtocolVersions": MobileBackupProtocolVersions,
+	})
+	if err != nil {
+		return err
+	}
+
+	reply, err := client.link.ReceiveProcessMessage(ctx)
+	if err != nil {
+		return err
+	}
+
+	if code := plistSignedInteger(reply["ErrorCode"]); code != 0 {
+		return &MobileBackupError{Command: "Hello", Code: code, Description: "no common protocol version"}
+	}
+	fmt.Printf("MobileBackup %s protocol version %v\n", client.device.serialNumber, reply["ProtocolVersion"])
+
+	return nil
+}
+
+// Backup backs the device up into its directory below the client's. It is
+// incremental unless full is set or no finished backup exists yet.
+func (client *MobileBackupClient) Backup(ctx context.Context, full bool, progress func(*OperationProgress)) error {
+	udid := client.device.udid()
+	backupDirectory := filepath.Join(client.directory, udid)
+	if err := os.MkdirAll(backupDirectory, 0755); err != nil {
+		return err
+	}
+
+	status, err := client.readStatus(udid)
+	if err != nil || status.SnapshotState != "finished" {
+		full = true
+	}
+
+	if err = client.writeInfo(udid); err != nil {
+		return err
+	}
+
+	fmt.Printf("MobileBackup %s starting backup into %s (full %t)\n", client.device.serialNumber, backupDirectory, full)
+	err = client.link.SendProcessMessage(ctx, map[string]interface{}{
+		"MessageName":      "Backup",
+		"TargetIdentifier": udid,
+		"SourceIdentifier": udid,
+		"Options": map[string]interface{}{
+			"ForceFullBackup": full,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.run(ctx, "Backup", progress)
+}
+
+// Restore restores the device from the finished backup in its directory.
+func (client *MobileBackupClient) Restore(ctx context.Context, options MobileBackupRestoreOptions, progress func(*OperationProgress)) error {
+	udid := client.device.udid()
+
+	status, err := client.readStatus(udid)
+	if err != nil {
+		return fmt.Errorf("no backup to restore: %w", err)
+	}
+	if status.SnapshotState != "finished" {
+		return fmt.Errorf("backup in %s is not finished", filepath.Join(client.directory, udid))
+	}
+
+	err = client.link.SendProcessMessage(ctx, map[string]interface{}{
+		"MessageName":      "Restore",
+		"TargetIdentifier": udid,
+		"SourceIdentifier": udid,
+		"Options": map[string]interface{}{
+			"RestoreShouldReboot":       options.Reboot,
+			"RestoreDontCopyBackup":     true,
+			"RestorePreserveCameraRoll": true,
+			"RestorePreserveSettings":   options.PreserveSettings,
+			"RestoreSystemFiles":        options.SystemFiles,
+			"RemoveItemsNotRestored":    options.RemoveNotRestored,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	return client.run(ctx, "Restore", progress)
+}
+
+func (client *MobileBackupClient) readStatus(udid string) (*MobileBackupStatus, error) {
+	data, err := ioutil.ReadFile(filepath.Join(client.directory, udid, "Status.plist"))
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MobileBackupStatus{}
+	if _, err = plist.Unmarshal(data, status); err != nil {
+		return nil, err
+	}
+
+	return status, nil
+}
+
+func (client *MobileBackupClient) writeInfo(udid string) error {
+	info := client.device.getInfo()
+	data, err := plist.Marshal(&MobileBackupInfo{
+		BuildVersion:     info.BuildVersion,
+		DeviceName:       info.DeviceName,
+		DisplayName:      info.DeviceName,
+		GUID:             strings.ToUpper(strings.ReplaceAll(uuid.New().String(), "-", "")),
+		LastBackupDate:   time.Now().UTC(),
+		ProductType:      info.ProductType,
+		ProductVersion:   info.ProductVersion,
+		SerialNumber:     info.SerialNumber,
+		TargetIdentifier: udid,
+		TargetType:       "Device",
+		UniqueIdentifier: strings.ToUpper(udid),
+	}, plist.XMLFormat)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filepath.Join(client.directory, udid, "Info.plist"), data, 0644)
+}
+
+// run serves the device's DeviceLink requests until it reports the outcome of command.
+func (client *MobileBackupClient) run(ctx context.Context, command string, progress func(*OperationProgress)) error {
+	report := func(status string, percent float64) {
+		if progress != nil {
+			progress(&OperationProgress{Command: command, Target: client.directory, Status: status, PercentComplete: uint64(percent)})
+		}
+	}
+
+	for {
+		message, err := client.link.ReceiveMessage(ctx)
+		if err != nil {
+			return err
+		}
+		name := message[0].(string)
+
+		if index, ok := mobileBackupProgressIndex[name]; ok && index < len(message) {
+			if percent, ok := message[index].(float64); ok && percent > 0 {
+				report(strings.TrimPrefix(name, "DLMessage"), math.Min(percent, 100))
+			}
+		}
+
+		switch name {
+		case "DLMessageDownloadFiles":
+			err = client.sendFiles(ctx, message)
+		case "DLMessageUploadFiles":
+			err = client.receiveFiles(ctx)
+		case "DLMessageGetFreeDiskSpace":
+			err = client.freeDiskSpace(ctx)
+		case "DLContentsOfDirectory":
+			err = client.contentsOfDirectory(ctx, message)
+		case "DLMessageCreateDirectory":
+			err = client.createDirectory(ctx, message)
+		case "DLMessageMoveFiles", "DLMessageMoveItems":
+			err = client.moveItems(ctx, message)
+		case "DLMessageRemoveFiles", "DLMessageRemoveItems":
+			err = client.removeItems(ctx, message)
+		case "DLMessageCopyItem":
+			err = client.copyItem(ctx, message)
+		case "DLMessagePurgeDiskSpace":
+			err = client.sendStatus(ctx, mobileBackupErrorGeneric, "Operation not supported", map[string]interface{}{})
+		case DeviceLinkMessageProcessMessage:
+			return client.result(command, message, report)
+		default:
+			fmt.Printf("MobileBackup %s unhandled message %s\n", client.device.serialNumber, name)
+			err = client.sendStatus(ctx, mobileBackupErrorGeneric, "Unsupported message", map[string]interface{}{})
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// result turns the device's final process message into the outcome of command.
+func (client *MobileBackupClient) result(command string, message []interface{}, report func(string, float64)) error {
+	var reply map[string]interface{}
+	if len(message) > 1 {
+		reply, _ = message[1].(map[string]interface{})
+	}
+
+	if code := plistSignedInteger(reply["ErrorCode"]); code != 0 {
+		description, _ := reply["ErrorDescription"].(string)
+		return &MobileBackupError{Command: command, Code: code, Description: description}
+	}
+
+	report("Complete", 100)
+	return nil
+}
+
+// sendStatus answers a DeviceLink request.
+func (client *MobileBackupClient) sendStatus(ctx context.Context, code int64, description string, value interface{}) error {
+	if description == "" {
+		description = deviceLinkEmptyParameter
+	}
+	return client.link.SendMessage(ctx, "DLMessageStatusResponse", code, description, value)
+}
+
+// localPath maps a path named by the device into the backup directory, never
+// leaving it.
+func (client *MobileBackupClient) localPath(path string) string {
+	return filepath.Join(client.directory, filepath.Clean("/"+path))
+}
+
+// sendFiles streams the requested files to the device, each as its name
+// followed by data blocks and a success or error block.
+func (client *MobileBackupClient) sendFiles(ctx context.Context, message []interface{}) error {
+	if len(message) < 2 {
+		return fmt.Errorf("mobilebackup2 malformed %s", message[0])
+	}
+	paths, _ := message[1].([]interface{})
+
+	conn := client.link.service.conn
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	failures := map[string]interface{}{}
+	for _, value := range paths {
+		path, _ := value.(string)
+
+		if err := writeMobileBackupString(conn, path); err != nil {
+			return err
+		}
+
+		err := client.sendFile(path)
+		var localErr *mobileBackupLocalError
+		if errors.As(err, &localErr) {
+			code := mobileBackupErrorCode(localErr.err)
+			failures[path] = map[string]interface{}{
+				"DLFileErrorString": localErr.err.Error(),
+				"DLFileErrorCode":   code,
+			}
+			err = writeMobileBackupBlock(conn, mobileBackupCodeErrorLocal, []byte(localErr.err.Error()))
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	// A zero length name ends the transfer
+	if err := binary.Write(conn, binary.BigEndian, uint32(0)); err != nil {
+		return err
+	}
+	stop()
+
+	if len(failures) > 0 {
+		return client.sendStatus(ctx, mobileBackupErrorMultiStatus, "Multi status", failures)
+	}
+	return client.sendStatus(ctx, 0, "", map[string]interface{}{})
+}
+
+// mobileBackupLocalError marks a failure reading a local file, which is
+// reported to the device instead of ending the transfer.
+type mobileBackupLocalError struct {
+	err error
+}
+
+func (err *mobileBackupLocalError) Error() string {
+	return err.err.Error()
+}
+
+func (client *MobileBackupClient) sendFile(path string) error {
+	file, err := os.Open(client.localPath(path))
+	if err != nil {
+		return &mobileBackupLocalError{err: err}
+	}
+	defer file.Close()
+
+	conn := client.link.service.conn
+	buffer := make([]byte, mobileBackupBlockSize)
+	for {
+		count, err := file.Read(buffer)
+		if count > 0 {
+			if err := writeMobileBackupBlock(conn, mobileBackupCodeFileData, buffer[:count]); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			// Part of the file went out already, the error block tells the device to drop it
+			return &mobileBackupLocalError{err: err}
+		}
+	}
+
+	return writeMobileBackupBlock(conn, mobileBackupCodeSuccess, nil)
+}
+
+// receiveFiles stores files the device uploads until it sends an empty name.
+func (client *MobileBackupClient) receiveFiles(ctx context.Context) error {
+	conn := client.link.service.conn
+	stop := watchContext(ctx, conn)
+	defer stop()
+
+	for {
+		// The device path comes first and is not needed on the host
+		devicePath, err := readMobileBackupString(conn)
+		if err != nil {
+			return err
+		}
+		if devicePath == "" {
+			break
+		}
+
+		path, err := readMobileBackupString(conn)
+		if err != nil {
+			return err
+		}
+
+		if err = client.receiveFile(path); err != nil {
+			return err
+		}
+	}
+	stop()
+
+	return client.sendStatus(ctx, 0, "", map[string]interface{}{})
+}
+
+func (client *MobileBackupClient) receiveFile(path string) error {
+	conn := client.link.service.conn
+	localPath := client.localPath(path)
+
+	var file *os.File
+	if err := os.MkdirAll(filepath.Dir(localPath), 0755); err == nil {
+		file, err = os.Create(localPath)
+		if err != nil {
+			fmt.Printf("MobileBackup %s can't store %s: %s\n", client.device.serialNumber, path, err)
+		}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+
+	for {
+		var length uint32
+		if err := binary.Read(conn, binary.BigEndian, &length); err != nil {
+			return err
+		}
+		if length == 0 {
+			return nil
+		}
+
+		code := make([]byte, 1)
+		if _, err := io.ReadFull(conn, code); err != nil {
+			return err
+		}
+
+		switch code[0] {
+		case mobileBackupCodeFileData:
+			// Keep draining the stream even when the file can't be written
+			destination := ioutil.Discard
+			if file != nil {
+				destination = file
+			}
+			if _, err := io.CopyN(destination, conn, int64(length-1)); err != nil {
+				return err
+			}
+		case mobileBackupCodeSuccess:
+			return nil
+		case mobileBackupCodeErrorRemote:
+			data := make([]byte, length-1)
+			if _, err := io.ReadFull(conn, data); err != nil {
+				return err
+			}
+			fmt.Printf("MobileBackup %s device failed sending %s: %s\n", client.device.serialNumber, path, data)
+			return nil
+		default:
+			return fmt.Errorf("mobilebackup2 unknown transfer code 0x%02x", code[0])
+		}
+	}
+}
+
+func (client *MobileBackupClient) freeDiskSpace(ctx context.Context) error {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(client.directory, &stat); err != nil {
+		return client.sendStatus(ctx, mobileBackupErrorCode(err), err.Error(), uint64(0))
+	}
+
+	return client.sendStatus(ctx, 0, "", uint64(stat.Bavail)*uint64(stat.Bsize))
+}
+
+func (client *MobileBackupClient) contentsOfDirectory(ctx context.Context, message []interface{}) error {
+	path, _ := messageArgument(message, 1).(string)
+
+	entries, err := ioutil.ReadDir(client.localPath(path))
+	if err != nil && !os.IsNotExist(err) {
+		return client.sendStatus(ctx, mobileBackupErrorCode(err), err.Error(), map[string]interface{}{})
+	}
+
+	contents := map[string]interface{}{}
+	for _, entry := range entries {
+		fileType := "DLFileTypeUnknown"
+		if entry.IsDir() {
+			fileType = "DLFileTypeDirectory"
+		} else if entry.Mode().IsRegular() {
+			fileType = "DLFileTypeRegular"
+		}
+
+		contents[entry.Name()] = map[string]interface{}{
+			"DLFileType":             fileType,
+			"DLFileSize":             uint64(entry.Size()),
+			"DLFileModificationDate": entry.ModTime(),
+		}
+	}
+
+	return client.sendStatus(ctx, 0, "", contents)
+}
+
+func (client *MobileBackupClient) createDirectory(ctx context.Context, message []interface{}) error {
+	path, _ := messageArgument(message, 1).(string)
+	return client.sendResult(ctx, os.MkdirAll(client.localPath(path), 0755))
+}
+
+func (client *MobileBackupClient) moveItems(ctx context.Context, message []interface{}) error {
+	items, _ := messageArgument(message, 1).(map[string]interface{})
+
+	var err error
+	for source, value := range items {
+		destination, _ := value.(string)
+		destinationPath := client.localPath(destination)
+
+		if err = os.RemoveAll(destinationPath); err != nil {
+			break
+		}
+		if err = os.MkdirAll(filepath.Dir(destinationPath), 0755); err != nil {
+			break
+		}
+		if err = os.Rename(client.localPath(source), destinationPath); err != nil {
+			break
+		}
+	}
+
+	return client.sendResult(ctx, err)
+}
+
+func (client *MobileBackupClient) removeItems(ctx context.Context, message []interface{}) error {
+	paths, _ := messageArgument(message, 1).([]interface{})
+
+	var err error
+	for _, value := range paths {
+		path, _ := value.(string)
+		if path == "" {
+			continue
+		}
+		if err = os.RemoveAll(client.localPath(path)); err != nil {
+			break
+		}
+	}
+
+	return client.sendResult(ctx, err)
+}
+
+func (client *MobileBackupClient) copyItem(ctx context.Context, message []interface{}) error {
+	source, _ := messageArgument(message, 1).(string)
+	destination, _ := messageArgument(message, 2).(string)
+
+	return client.sendResult(ctx, copyTree(client.localPath(source), client.localPath(destination)))
+}
+
+// sendResult answers a file system request with success or the error's code.
+func (client *MobileBackupClient) sendResult(ctx context.Context, err error) error {
+	if err != nil {
+		fmt.Printf("MobileBackup %s file request failed %s\n", client.device.serialNumber, err)
+		return client.sendStatus(ctx, mobileBackupErrorCode(err), err.Error(), map[string]interface{}{})
+	}
+	return client.sendStatus(ctx, 0, "", map[string]interface{}{})
+}
+
+// copyTree copies a file or directory recursively.
+func copyTree(source string, destination string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		input, err := os.Open(source)
+		if err != nil {
+			return err
+		}
+		defer input.Close()
+
+		output, err := os.Create(destination)
+		if err != nil {
+			return err
+		}
+		if _, err = io.Copy(output, input); err != nil {
+			output.Close()
+			return err
+		}
+		return output.Close()
+	}
+
+	if err = os.MkdirAll(destination, info.Mode().Perm()); err != nil {
+		return err
+	}
+
+	entries, err := ioutil.ReadDir(source)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if err = copyTree(filepath.Join(source, entry.Name()), filepath.Join(destination, entry.Name())); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// mobileBackupErrorCode translates a local error into the code reported to the device.
+func mobileBackupErrorCode(err error) int64 {
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return mobileBackupErrorNotFound
+	case errors.Is(err, os.ErrExist):
+		return mobileBackupErrorExists
+	case errors.Is(err, syscall.ENOTDIR):
+		return mobileBackupErrorNotDir
+	case errors.Is(err, syscall.EISDIR):
+		return mobileBackupErrorIsDir
+	case errors.Is(err, syscall.ENOSPC):
+		return mobileBackupErrorNoSpace
+	case errors.Is(err, syscall.EIO):
+		return mobileBackupErrorIO
+	}
+	return mobileBackupErrorGeneric
+}
+
+func writeMobileBackupString(writer io.Writer, value string) error {
+	if err := binary.Write(writer, binary.BigEndian, uint32(len(value))); err != nil {
+		return err
+	}
+	_, err := io.WriteString(writer, value)
+	return err
+}
+
+func readMobileBackupString(reader io.Reader) (string, error) {
+	var length uint32
+	if err := binary.Read(reader, binary.BigEndian, &length); err != nil {
+		return "", err
+	}
+	if length > PropertyListMaxLength {
+		return "", fmt.Errorf("mobilebackup2 name length %d exceeds limit", length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
+
+// writeMobileBackupBlock writes one block of a file transfer, its length
+// counting the code byte.
+func writeMobileBackupBlock(writer io.Writer, code byte, data []byte) error {
+	header := make([]byte, 5)
+	binary.BigEndian.PutUint32(header, uint32(len(data)+1))
+	header[4] = code
+
+	if _, err := writer.Write(append(header, data...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+func messageArgument(message []interface{}, index int) interface{} {
+	if index < len(message) {
+		return message[index]
+	}
+	return nil
+}
+
+// plistSignedInteger returns a decoded plist number as a signed integer, zero if
+// value is not a number.
+func plistSignedInteger(value interface{}) int64 {
+	switch number := value.(type) {
+	case uint64:
+		return int64(number)
+	case int64:
+		return number
+	case float64:
+		return int64(number)
+	}
+	return 0
+}
+
+// handleBackup serves the mobilebackup2 endpoints, streaming progress like the
+// app endpoints:
+//
+//	POST /v1/devices/{id}/backup?full=true
+//	POST /v1/devices/{id}/backup/restore?system=true&reboot=false&settings=false&remove=true
+//
+// Backups live below the -backups directory, one directory per UDID.
+func handleBackup(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	if request.Method != http.MethodPost || (path != "" && path != "restore") {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on backup/%s", request.Method, path))
+		return
+	}
+
+	query := request.URL.Query()
+	flag := func(name string, value bool) bool {
+		switch query.Get(name) {
+		case "true", "1":
+			return true
+		case "false", "0":
+			return false
+		}
+		return value
+	}
+
+	client, err := device.OpenMobileBackup(request.Context(), *backupsFlag)
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	streamProgress(device, writer, DeviceEventBackupProgress, func(progress func(*OperationProgress)) error {
+		if path == "restore" {
+			return client.Restore(request.Context(), MobileBackupRestoreOptions{
+				SystemFiles:       flag("system", false),
+				Reboot:            flag("reboot", true),
+				PreserveSettings:  flag("settings", true),
+				RemoveNotRestored: flag("remove", false),
+			}, progress)
+		}
+		return client.Backup(request.Context(), flag("full", false), progress)
+	})
+}
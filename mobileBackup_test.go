@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"howett.net/plist"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testDeviceLink plays the device end of a DeviceLink connection.
+type testDeviceLink struct {
+	t    *testing.T
+	conn net.Conn
+}
+
+func (link *testDeviceLink) send(message ...interface{}) {
+	data, err := encodePropertyList(message)
+	if err != nil {
+		link.t.Error(err)
+		return
+	}
+	if _, err = link.conn.Write(data); err != nil {
+		link.t.Error(err)
+	}
+}
+
+func (link *testDeviceLink) receive() []interface{} {
+	data, err := readPropertyList(link.conn)
+	if err != nil {
+		link.t.Error(err)
+		return nil
+	}
+
+	var message []interface{}
+	if _, err = plist.Unmarshal(data, &message); err != nil {
+		link.t.Error(err)
+	}
+	return message
+}
+
+// receiveStatus reads a status response and returns its code.
+func (link *testDeviceLink) receiveStatus() int64 {
+	message := link.receive()
+	if len(message) < 2 || message[0] != "DLMessageStatusResponse" {
+		link.t.Errorf("expected a status response, got %v", message)
+		return 0
+	}
+	return plistSignedInteger(message[1])
+}
+
+func newTestMobileBackup(t *testing.T) (*MobileBackupClient, *testDeviceLink) {
+	host, device := net.Pipe()
+	t.Cleanup(func() {
+		host.Close()
+		device.Close()
+	})
+
+	service := &ServiceConnection{info: &LockdownServiceInfo{Service: MobileBackupServiceName}, conn: host}
+	client := &MobileBackupClient{
+		link:      &DeviceLinkService{service: service},
+		device:    &RemoteDevice{serialNumber: "test"},
+		directory: t.TempDir(),
+	}
+
+	return client, &testDeviceLink{t: t, conn: device}
+}
+
+func TestMobileBackupTransfersFiles(t *testing.T) {
+	client, link := newTestMobileBackup(t)
+
+	stored := bytes.Repeat([]byte("backup"), mobileBackupBlockSize/4)
+	if err := ioutil.WriteFile(filepath.Join(client.directory, "Manifest.db"), stored, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The context can be cancelled, so every transfer watches it
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result := make(chan error, 1)
+	go func() {
+		result <- client.run(ctx, "Backup", nil)
+	}()
+
+	link.send("DLMessageDownloadFiles", []interface{}{"Manifest.db", "Missing"}, map[string]interface{}{}, 0.0)
+
+	var received []byte
+	for _, expected := range []string{"Manifest.db", "Missing"} {
+		name, err := readMobileBackupString(link.conn)
+		if err != nil || name != expected {
+			t.Fatalf("expected %s to be sent, got %q (%v)", expected, name, err)
+		}
+
+		for done := false; !done; {
+			var length uint32
+			if err = binary.Read(link.conn, binary.BigEndian, &length); err != nil {
+				t.Fatal(err)
+			}
+			block := make([]byte, length)
+			if _, err = io.ReadFull(link.conn, block); err != nil {
+				t.Fatal(err)
+			}
+
+			switch block[0] {
+			case mobileBackupCodeFileData:
+				received = append(received, block[1:]...)
+			case mobileBackupCodeSuccess:
+				done = expected == "Manifest.db"
+				if !done {
+					t.Fatalf("missing file reported as sent")
+				}
+			case mobileBackupCodeErrorLocal:
+				done = expected == "Missing"
+				if !done {
+					t.Fatalf("failed sending %s: %s", expected, block[1:])
+				}
+			}
+		}
+	}
+	if name, err := readMobileBackupString(link.conn); err != nil || name != "" {
+		t.Fatalf("expected the transfer to end, got %q (%v)", name, err)
+	}
+	if !bytes.Equal(received, stored) {
+		t.Fatalf("sent %d bytes, expected %d", len(received), len(stored))
+	}
+	if code := link.receiveStatus(); code != mobileBackupErrorMultiStatus {
+		t.Fatalf("expected a multi status for the missing file, got %d", code)
+	}
+
+	uploaded := bytes.Repeat([]byte("device"), 1000)
+	link.send("DLMessageUploadFiles", map[string]interface{}{}, 0.0)
+	writeMobileBackupString(link.conn, "/var/mobile/Library/file")
+	writeMobileBackupString(link.conn, "test/00/00aa")
+	writeMobileBackupBlock(link.conn, mobileBackupCodeFileData, uploaded[:4000])
+	writeMobileBackupBlock(link.conn, mobileBackupCodeFileData, uploaded[4000:])
+	writeMobileBackupBlock(link.conn, mobileBackupCodeSuccess, nil)
+	// A zero length name ends the upload, written as its length only since a
+	// pipe would block on the empty name
+	binary.Write(link.conn, binary.BigEndian, uint32(0))
+	if code := link.receiveStatus(); code != 0 {
+		t.Fatalf("upload failed with %d", code)
+	}
+
+	link.send(DeviceLinkMessageProcessMessage, map[string]interface{}{"ErrorCode": 0})
+	if err := <-result; err != nil {
+		t.Fatalf("backup failed: %v", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(client.directory, "test", "00", "00aa"))
+	if err != nil || !bytes.Equal(data, uploaded) {
+		t.Fatalf("uploaded file not stored: %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(client.directory, "Missing")); !os.IsNotExist(err) {
+		t.Fatalf("missing file created: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+)
+
+const NotificationProxyServiceName = "com.apple.mobile.notification_proxy"
+
+// Darwin notifications commonly observed or posted through notification_proxy
+const (
+	NotificationApplicationInstalled   = "com.apple.mobile.application_installed"
+	NotificationApplicationUninstalled = "com.apple.mobile.application_uninstalled"
+	NotificationSyncWillStart          = "com.apple.itunes-mobdev.syncWillStart"
+	NotificationSyncDidStart           = "com.apple.itunes-mobdev.syncDidStart"
+	NotificationSyncDidFinish          = "com.apple.itunes-mobdev.syncDidFinish"
+	NotificationSyncLockRequest        = "com.apple.itunes-mobdev.syncLockRequest"
+	NotificationBackupDomainChanged    = "com.apple.mobile.backup.domain_changed"
+)
+
+type NotificationProxyMessage struct {
+	Command string `plist:"Command"`
+	Name    string `plist:"Name,omitempty"`
+}
+
+// NotificationEvent is the data of a DeviceEventNotification.
+type NotificationEvent struct {
+	Name string `json:"name"`
+}
+
+// NotificationProxy keeps one notification_proxy connection per device that
+// observes the requested notifications and publishes them as device events.
+// The service can't stop observing a name, so removing one reconnects with
+// the remaining names.
+type NotificationProxy struct {
+	device *RemoteDevice
+
+	mutex    sync.Mutex
+	service  *ServiceConnection
+	observed map[string]bool
+}
+
+func newNotificationProxy(device *RemoteDevice) *NotificationProxy {
+	return &NotificationProxy{
+		device:   device,
+		observed: make(map[string]bool),
+	}
+}
+
+// connect opens the connection if there is none. The caller holds mutex.
+func (proxy *NotificationProxy) connect(ctx context.Context) error {
+	if proxy.service != nil {
+		return nil
+	}
+
+	service, err := proxy.device.OpenService(ctx, NotificationProxyServiceName)
+	if err != nil {
+		return err
+	}
+	proxy.service = service
+	go proxy.run(service)
+
+	return nil
+}
+
+// disconnect shuts the connection down. The caller holds mutex.
+func (proxy *NotificationProxy) disconnect() {
+	if proxy.service == nil {
+		return
+	}
+
+	proxy.service.Send(context.Background(), &NotificationProxyMessage{Command: "Shutdown"})
+	proxy.service.Close()
+	proxy.service = nil
+}
+
+// Observe starts relaying names in addition to those already observed.
+func (proxy *NotificationProxy) Observe(ctx context.Context, names ...string) error {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	if err := proxy.connect(ctx); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if proxy.observed[name] {
+			continue
+		}
+		if err := proxy.service.Send(ctx, &NotificationProxyMessage{Command: "ObserveNotification", Name: name}); err != nil {
+			return err
+		}
+		proxy.observed[name] = true
+	}
+
+	return nil
+}
+
+// Unobserve stops relaying names, reconnecting to observe the rest.
+func (proxy *NotificationProxy) Unobserve(ctx context.Context, names ...string) error {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	for _, name := range names {
+		delete(proxy.observed, name)
+	}
+
+	proxy.disconnect()
+	if len(proxy.observed) == 0 {
+		return nil
+	}
+
+	if err := proxy.connect(ctx); err != nil {
+		return err
+	}
+	for name := range proxy.observed {
+		if err := proxy.service.Send(ctx, &NotificationProxyMessage{Command: "ObserveNotification", Name: name}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Post posts name on the device.
+func (proxy *NotificationProxy) Post(ctx context.Context, name string) error {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	if proxy.service != nil {
+		return proxy.service.Send(ctx, &NotificationProxyMessage{Command: "PostNotification", Name: name})
+	}
+
+	service, err := proxy.device.OpenService(ctx, NotificationProxyServiceName)
+	if err != nil {
+		return err
+	}
+	defer service.Close()
+
+	if err = service.Send(ctx, &NotificationProxyMessage{Command: "PostNotification", Name: name}); err != nil {
+		return err
+	}
+	return service.Send(ctx, &NotificationProxyMessage{Command: "Shutdown"})
+}
+
+// Observed returns the observed names in order.
+func (proxy *NotificationProxy) Observed() []string {
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	names := make([]string, 0, len(proxy.observed))
+	for name := range proxy.observed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// run publishes relayed notifications until the connection ends.
+func (proxy *NotificationProxy) run(service *ServiceConnection) {
+	for {
+		message := &NotificationProxyMessage{}
+		if err := service.Receive(context.Background(), message); err != nil {
+			fmt.Printf("NotificationProxy %s connection ended: %s\n", proxy.device.serialNumber, err)
+			break
+		}
+
+		if message.Command == "ProxyDeath" {
+			break
+		}
+		if message.Command != "RelayNotification" {
+			fmt.Printf("NotificationProxy %s unexpected %s\n", proxy.device.serialNumber, message.Command)
+			continue
+		}
+
+		proxy.device.hub.events <- &DeviceEvent{
+			Type:   DeviceEventNotification,
+			Device: proxy.device.serialNumber,
+			Data:   &NotificationEvent{Name: message.Name},
+		}
+	}
+
+	proxy.mutex.Lock()
+	defer proxy.mutex.Unlock()
+
+	// Unless a new connection already replaced this one, nothing is observed anymore
+	if proxy.service == service {
+		service.Close()
+		proxy.service = nil
+		proxy.observed = make(map[string]bool)
+	}
+}
+
+// handleNotifications serves the notification_proxy endpoints. Relayed
+// notifications arrive as Notification events on /v1/events.
+//
+//	GET    /v1/devices/{id}/notifications
+//	POST   /v1/devices/{id}/notifications/observe?name=com.apple.mobile.application_installed
+//	DELETE /v1/devices/{id}/notifications/observe?name=...
+//	POST   /v1/devices/{id}/notifications/post?name=...
+func handleNotifications(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	proxy := device.notificationProxy
+	names := request.URL.Query()["name"]
+
+	if path != "" && len(names) == 0 {
+		writeError(writer, http.StatusBadRequest, fmt.Errorf("missing name parameter"))
+		return
+	}
+
+	var err error
+	switch {
+	case request.Method == http.MethodGet && path == "":
+	case request.Method == http.MethodPost && path == "observe":
+		err = proxy.Observe(request.Context(), names...)
+	case request.Method == http.MethodDelete && path == "observe":
+		err = proxy.Unobserve(request.Context(), names...)
+	case request.Method == http.MethodPost && path == "post":
+		for _, name := range names {
+			if err = proxy.Post(request.Context(), name); err != nil {
+				break
+			}
+		}
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on notifications/%s", request.Method, path))
+		return
+	}
+
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	writeJSON(writer, http.StatusOK, map[string][]string{"observed": proxy.Observed()})
+}
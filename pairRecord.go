@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/google/uuid"
+	"howett.net/plist"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// PairRecord is the host side of a pairing, stored by usbmuxd as <UDID>.plist.
+type PairRecord struct {
+	DeviceCertificate []byte `plist:"DeviceCertificate"`
+	HostCertificate   []byte `plist:"HostCertificate"`
+	HostPrivateKey    []byte `plist:"HostPrivateKey"`
+	RootCertificate   []byte `plist:"RootCertificate"`
+	RootPrivateKey    []byte `plist:"RootPrivateKey"`
+	HostID            string `plist:"HostID"`
+	SystemBUID        string `plist:"SystemBUID"`
+	WiFiMACAddress    string `plist:"WiFiMACAddress,omitempty"`
+	EscrowBag         []byte `plist:"EscrowBag,omitempty"`
+}
+
+// PairRecordStore keeps pair records in a directory laid out like usbmuxd's.
+type PairRecordStore struct {
+	directory string
+	mutex     sync.Mutex
+}
+
+// SystemConfiguration is the store wide identity shared by all pairings.
+type SystemConfiguration struct {
+	SystemBUID string `plist:"SystemBUID"`
+}
+
+func (store *PairRecordStore) path(udid string) string {
+	return filepath.Join(store.directory, udid+".plist")
+}
+
+func (store *PairRecordStore) load(udid string) (*PairRecord, error) {
+	data, err := ioutil.ReadFile(store.path(udid))
+	if err != nil {
+		return nil, err
+	}
+
+	record := &PairRecord{}
+	if _, err = plist.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("pair record %s: %w", udid, err)
+	}
+
+	return record, nil
+}
+
+func (store *PairRecordStore) save(udid string, record *PairRecord) error {
+	return store.write(store.path(udid), record)
+}
+
+// systemBUID returns the host identifier sent with every pairing, creating it
+// on first use.
+func (store *PairRecordStore) systemBUID() (string, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	path := filepath.Join(store.directory, "SystemConfiguration.plist")
+	configuration := &SystemConfiguration{}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		if _, err = plist.Unmarshal(data, configuration); err != nil {
+			return "", fmt.Errorf("system configuration: %w", err)
+		}
+		if configuration.SystemBUID != "" {
+			return configuration.SystemBUID, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	configuration.SystemBUID = strings.ToUpper(uuid.New().String())
+	if err = store.write(path, configuration); err != nil {
+		return "", err
+	}
+
+	return configuration.SystemBUID, nil
+}
+
+func (store *PairRecordStore) write(path string, value interface{}) error {
+	data, err := plist.Marshal(value, plist.XMLFormat)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(store.directory, 0700); err != nil {
+		return err
+	}
+
+	// Write next to the target and rename so readers never see half a file
+	temporaryPath := path + ".tmp"
+	if err = ioutil.WriteFile(temporaryPath, data, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryPath, path)
+}
+
+// tlsConfig returns a client configuration authenticating as the paired host.
+func (record *PairRecord) tlsConfig() (*tls.Config, error) {
+	certificate, err := tls.X509KeyPair(record.HostCertificate, record.HostPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("pair record host certificate: %w", err)
+	}
+
+	return &tls.Config{
+		// The device asks for a client certificate without naming an acceptable CA
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &certificate, nil
+		},
+		// Devices present a self signed certificate created at pairing time
+		InsecureSkipVerify: true,
+		MinVersion:         tls.VersionTLS10,
+	}, nil
+}
+
+// pairRecord loads the host's pair record for the device. Records are stored
+// under the UDID, which is not always the USB serial number.
+func (device *RemoteDevice) pairRecord() (*PairRecord, error) {
+	record, err := device.hub.pairRecords.load(device.udid())
+	if err != nil && device.udid() != device.serialNumber {
+		return device.hub.pairRecords.load(device.serialNumber)
+	}
+	return record, err
+}
+
+// tlsConfig loads the device's pair record and builds a TLS client configuration from it.
+func (device *RemoteDevice) tlsConfig() (*tls.Config, error) {
+	record, err := device.pairRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	return record.tlsConfig()
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	PairingKeySize = 2048
+
+	// Validity of the certificates created for a pairing
+	PairingCertificateLifetime = 10 * 365 * 24 * time.Hour
+
+	// How often Pair asks again while the trust dialog is showing
+	PairingRetryInterval = time.Second
+)
+
+// LockdownPairRecord is the public part of a pair record sent to lockdownd.
+type LockdownPairRecord struct {
+	DeviceCertificate []byte `plist:"DeviceCertificate"`
+	HostCertificate   []byte `plist:"HostCertificate"`
+	RootCertificate   []byte `plist:"RootCertificate"`
+	HostID            string `plist:"HostID"`
+	SystemBUID        string `plist:"SystemBUID"`
+}
+
+type LockdownPairingOptions struct {
+	ExtendedPairingErrors bool `plist:"ExtendedPairingErrors"`
+}
+
+// Pair creates a new pairing with the device and saves it to store under the
+// device's UDID. While the device shows the trust dialog or is locked the
+// request is repeated until the user answers or ctx is done.
+func (service *LockdownService) Pair(ctx context.Context, store *PairRecordStore) (*PairRecord, error) {
+	publicKeyValue, err := service.GetValue(ctx, "", "DevicePublicKey")
+	if err != nil {
+		return nil, err
+	}
+	publicKeyPEM, ok := publicKeyValue.([]byte)
+	if !ok {
+		return nil, errors.New("lockdown DevicePublicKey is not data")
+	}
+
+	udidValue, err := service.GetValue(ctx, "", "UniqueDeviceID")
+	if err != nil {
+		return nil, err
+	}
+	udid, _ := udidValue.(string)
+	if udid == "" {
+		return nil, errors.New("lockdown UniqueDeviceID is missing")
+	}
+
+	systemBUID, err := store.systemBUID()
+	if err != nil {
+		return nil, err
+	}
+
+	record, err := generatePairRecord(publicKeyPEM, systemBUID)
+	if err != nil {
+		return nil, err
+	}
+
+	request := &LockdownRequest{
+		Request:         "Pair",
+		ProtocolVersion: LockdownProtocolVersion,
+		PairRecord:      record.lockdownPairRecord(),
+		PairingOptions:  &LockdownPairingOptions{ExtendedPairingErrors: true},
+	}
+
+	for {
+		var reply map[string]interface{}
+		reply, err = service.call(ctx, request)
+		if err == nil {
+			record.EscrowBag, _ = reply["EscrowBag"].([]byte)
+			break
+		}
+
+		if !errors.Is(err, &LockdownError{Code: LockdownErrorPairingDialog}) &&
+			!errors.Is(err, &LockdownError{Code: LockdownErrorPasswordProtected}) {
+			return nil, err
+		}
+
+		fmt.Printf("LockdownService waiting for the user to trust this host (%s)\n", err)
+		select {
+		case <-time.After(PairingRetryInterval):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if wifiAddress, err := service.GetValue(ctx, "", "WiFiAddress"); err == nil {
+		record.WiFiMACAddress, _ = wifiAddress.(string)
+	}
+
+	if err = store.save(udid, record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// ValidatePair checks that the device still accepts record.
+func (service *LockdownService) ValidatePair(ctx context.Context, record *PairRecord) error {
+	_, err := service.call(ctx, &LockdownRequest{
+		Request:         "ValidatePair",
+		ProtocolVersion: LockdownProtocolVersion,
+		PairRecord:      record.lockdownPairRecord(),
+	})
+	return err
+}
+
+// Pair pairs the host with the device through the device's lockdown client.
+func (device *RemoteDevice) Pair(ctx context.Context) (*PairRecord, error) {
+	return device.pair(ctx, device.lockdown())
+}
+
+// pair runs one pairing at a time per device, as each puts up the trust dialog.
+func (device *RemoteDevice) pair(ctx context.Context, lockdown *LockdownService) (*PairRecord, error) {
+	device.pairingMutex.Lock()
+	defer device.pairingMutex.Unlock()
+
+	record, err := lockdown.Pair(ctx, device.hub.pairRecords)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("RemoteDevice %s paired as host %s\n", device.serialNumber, record.HostID)
+
+	return record, nil
+}
+
+// startSession starts a session on lockdown with the device's pair record. A
+// device that no longer knows the record's host is paired again, once.
+func (device *RemoteDevice) startSession(ctx context.Context, lockdown *LockdownService) (*PairRecord, error) {
+	record, err := device.pairRecord()
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockdown.StartSession(ctx, record)
+	if !errors.Is(err, &LockdownError{Code: LockdownErrorInvalidHostID}) {
+		if err != nil {
+			return nil, err
+		}
+		return record, nil
+	}
+
+	fmt.Printf("RemoteDevice %s does not know host %s, pairing again\n", device.serialNumber, record.HostID)
+	if record, err = device.pair(ctx, lockdown); err != nil {
+		return nil, err
+	}
+	if err = lockdown.StartSession(ctx, record); err != nil {
+		return nil, err
+	}
+
+	return record, nil
+}
+
+// handlePairing serves the pairing endpoints. Pairing waits for the user to
+// trust the host on the device for as long as the request lasts.
+//
+//	GET  /v1/devices/{id}/pairing    (whether the device accepts the stored pair record)
+//	POST /v1/devices/{id}/pairing
+func handlePairing(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	if path != "" {
+		writeError(writer, http.StatusNotFound, fmt.Errorf("unknown pairing request %s", path))
+		return
+	}
+
+	switch request.Method {
+	case http.MethodGet:
+		record, err := device.pairRecord()
+		if os.IsNotExist(err) {
+			writeJSON(writer, http.StatusOK, map[string]interface{}{"paired": false})
+			return
+		}
+		if err == nil {
+			err = device.lockdown().ValidatePair(request.Context(), record)
+		}
+
+		paired := err == nil
+		if errors.Is(err, &LockdownError{Code: LockdownErrorInvalidHostID}) {
+			err = nil
+		}
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{"paired": paired})
+
+	case http.MethodPost:
+		record, err := device.Pair(request.Context())
+		if errors.Is(err, &LockdownError{Code: LockdownErrorUserDeniedPairing}) {
+			writeError(writer, http.StatusForbidden, err)
+			return
+		}
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{"paired": true, "hostId": record.HostID})
+
+	default:
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on pairing", request.Method))
+	}
+}
+
+func (record *PairRecord) lockdownPairRecord() *LockdownPairRecord {
+	return &LockdownPairRecord{
+		DeviceCertificate: record.DeviceCertificate,
+		HostCertificate:   record.HostCertificate,
+		RootCertificate:   record.RootCertificate,
+		HostID:            record.HostID,
+		SystemBUID:        record.SystemBUID,
+	}
+}
+
+// generatePairRecord creates a root CA, a host certificate and a certificate
+// for the device's public key, the same set libimobiledevice generates.
+func generatePairRecord(devicePublicKeyPEM []byte, systemBUID string) (*PairRecord, error) {
+	block, _ := pem.Decode(devicePublicKeyPEM)
+	if block == nil {
+		return nil, errors.New("device public key is not PEM encoded")
+	}
+	devicePublicKey, err := x509.ParsePKCS1PublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("device public key: %w", err)
+	}
+
+	rootKey, err := rsa.GenerateKey(rand.Reader, PairingKeySize)
+	if err != nil {
+		return nil, err
+	}
+	hostKey, err := rsa.GenerateKey(rand.Reader, PairingKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(PairingCertificateLifetime)
+
+	rootTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		SubjectKeyId:          subjectKeyID(&rootKey.PublicKey),
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTemplate, rootTemplate, &rootKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("root certificate: %w", err)
+	}
+	rootCertificate, err := x509.ParseCertificate(rootDER)
+	if err != nil {
+		return nil, err
+	}
+
+	leafTemplate := func(publicKey *rsa.PublicKey) *x509.Certificate {
+		return &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			NotBefore:             notBefore,
+			NotAfter:              notAfter,
+			BasicConstraintsValid: true,
+			IsCA:                  false,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+			SubjectKeyId:          subjectKeyID(publicKey),
+		}
+	}
+
+	hostDER, err := x509.CreateCertificate(rand.Reader, leafTemplate(&hostKey.PublicKey), rootCertificate, &hostKey.PublicKey, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("host certificate: %w", err)
+	}
+	deviceDER, err := x509.CreateCertificate(rand.Reader, leafTemplate(devicePublicKey), rootCertificate, devicePublicKey, rootKey)
+	if err != nil {
+		return nil, fmt.Errorf("device certificate: %w", err)
+	}
+
+	return &PairRecord{
+		DeviceCertificate: pemEncode("CERTIFICATE", deviceDER),
+		HostCertificate:   pemEncode("CERTIFICATE", hostDER),
+		HostPrivateKey:    pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(hostKey)),
+		RootCertificate:   pemEncode("CERTIFICATE", rootDER),
+		RootPrivateKey:    pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rootKey)),
+		HostID:            strings.ToUpper(uuid.New().String()),
+		SystemBUID:        systemBUID,
+	}, nil
+}
+
+func subjectKeyID(publicKey *rsa.PublicKey) []byte {
+	digest := sha1.Sum(x509.MarshalPKCS1PublicKey(publicKey))
+	return digest[:]
+}
+
+func pemEncode(blockType string, data []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: data})
+}
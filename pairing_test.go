@@ -0,0 +1,92 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func parseTestCertificate(t *testing.T, name string, data []byte) *x509.Certificate {
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		t.Fatalf("%s certificate is not PEM encoded", name)
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatalf("%s certificate: %v", name, err)
+	}
+	return certificate
+}
+
+func TestGeneratePairRecord(t *testing.T) {
+	deviceKey, err := rsa.GenerateKey(rand.Reader, PairingKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := generatePairRecord(pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&deviceKey.PublicKey)), "SYSTEM-BUID")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if record.HostID == "" || record.SystemBUID != "SYSTEM-BUID" {
+		t.Fatalf("unexpected identity: host %q, system %q", record.HostID, record.SystemBUID)
+	}
+
+	root := parseTestCertificate(t, "root", record.RootCertificate)
+	host := parseTestCertificate(t, "host", record.HostCertificate)
+	device := parseTestCertificate(t, "device", record.DeviceCertificate)
+
+	if !root.IsCA {
+		t.Fatalf("root certificate is not a CA")
+	}
+	if err = root.CheckSignatureFrom(root); err != nil {
+		t.Fatalf("root certificate is not self signed: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(root)
+	for name, certificate := range map[string]*x509.Certificate{"host": host, "device": device} {
+		if certificate.IsCA {
+			t.Errorf("%s certificate is a CA", name)
+		}
+		_, err = certificate.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+		if err != nil {
+			t.Errorf("%s certificate does not chain to the root: %v", name, err)
+		}
+	}
+
+	if publicKey, ok := device.PublicKey.(*rsa.PublicKey); !ok || !publicKey.Equal(&deviceKey.PublicKey) {
+		t.Fatalf("device certificate is not for the device's key")
+	}
+
+	// The private keys belong to their certificates
+	if _, err = tls.X509KeyPair(record.HostCertificate, record.HostPrivateKey); err != nil {
+		t.Fatalf("host key: %v", err)
+	}
+	if _, err = tls.X509KeyPair(record.RootCertificate, record.RootPrivateKey); err != nil {
+		t.Fatalf("root key: %v", err)
+	}
+	if _, err = record.tlsConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGeneratePairRecordRejectsBadKey(t *testing.T) {
+	if _, err := generatePairRecord([]byte("not a key"), "SYSTEM-BUID"); err == nil {
+		t.Fatalf("expected an error for a key that is not PEM encoded")
+	}
+}
+
+func TestHandlePairingMethod(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	handlePairing(&RemoteDevice{serialNumber: "test"}, recorder, httptest.NewRequest(http.MethodPut, "/v1/devices/test/pairing", nil), "")
+	if recorder.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected %d, got %d", http.StatusMethodNotAllowed, recorder.Code)
+	}
+}
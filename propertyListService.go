@@ -1,20 +1,41 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"gopkg.in/restruct.v1"
 	"howett.net/plist"
+	"io"
+	"sync"
+	"time"
 )
 
+var ErrPropertyListServiceClosed = errors.New("property list service connection closed")
+
+// Largest plist message accepted from a device, anything bigger means the stream is corrupt
+const PropertyListMaxLength = 32 * 1024 * 1024
+
+// Time allowed for the TLS handshake of a service connected with encrypted set
+const PropertyListHandshakeTimeout = 30 * time.Second
+
 type PropertyListServiceClient interface {
 	connected()
-	plistReceived(data map[string]interface{})
+	// data is the decoded top level value, usually a dictionary but arrays and
+	// scalars are delivered too
+	plistReceived(data interface{})
+	plistError(err error)
 }
 
 type PropertyListServiceDescriptor struct {
 	port      uint16
 	encrypted bool
+
+	// Some services only authenticate with a TLS handshake and then carry on in
+	// plain text over the same connection
+	handshakeOnly bool
 }
 
 type PropertyListDatagram struct {
@@ -22,75 +43,254 @@ type PropertyListDatagram struct {
 	Data   []byte
 }
 
+// PropertyListDecoder splits a byte stream into length prefixed plist messages,
+// regardless of how the stream was segmented.
+type PropertyListDecoder struct {
+	buffer    []byte
+	maxLength uint32
+}
+
 type PropertyListService struct {
 	descriptor PropertyListServiceDescriptor
+	device     *RemoteDevice
 	channel    *TCPChannel
 	handler    PropertyListServiceClient
 	sending    *PropertyListDatagram
-	receiving  *PropertyListDatagram
+	decoder    PropertyListDecoder
+
+	// Once TLS was started, plists are read and written through stream instead
+	// of the channel callbacks
+	stream      io.ReadWriter
+	streamMutex sync.Mutex
+
+	// The channel passes through several closing states, the handler hears
+	// about it once
+	closeOnce sync.Once
+}
+
+// decode appends data to the stream and returns the bodies of all messages it
+// completed. After an error the stream can't be resynchronised.
+func (decoder *PropertyListDecoder) decode(data []byte) ([][]byte, error) {
+	decoder.buffer = append(decoder.buffer, data...)
+
+	var messages [][]byte
+	for len(decoder.buffer) >= 4 {
+		length := binary.BigEndian.Uint32(decoder.buffer)
+		if length > decoder.maxLength {
+			return messages, fmt.Errorf("plist length %d exceeds limit of %d", length, decoder.maxLength)
+		}
+		if uint32(len(decoder.buffer)-4) < length {
+			break
+		}
+
+		messages = append(messages, decoder.buffer[4:4+length])
+		decoder.buffer = decoder.buffer[4+length:]
+	}
+
+	if len(decoder.buffer) == 0 {
+		decoder.buffer = nil
+	}
+
+	return messages, nil
 }
 
 func (service *PropertyListService) connectionStateChange(state int) {
 	fmt.Printf("PropertyListService state change %d\n", state)
 	switch state {
 	case TCPStateConnected:
-		service.handler.connected()
+		if !service.descriptor.encrypted {
+			service.handler.connected()
+			return
+		}
+
+		// The handshake needs data delivered by the goroutine calling us
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), PropertyListHandshakeTimeout)
+			defer cancel()
+
+			config, err := service.device.tlsConfig()
+			if err == nil {
+				err = service.startTLS(ctx, config, service.descriptor.handshakeOnly)
+			}
+			if err != nil {
+				fmt.Printf("PropertyListService (%d) TLS error %s\n", service.descriptor.port, err)
+				service.handler.plistError(err)
+				service.channel.Abort()
+				return
+			}
+
+			service.handler.connected()
+		}()
+	case TCPStateCloseWait:
+		// No reply can arrive anymore, so close our side as well
+		service.closed()
+		service.channel.Close()
+	case TCPStateClosed, TCPStateRefused:
+		service.closed()
 	}
 }
 
-func (service *PropertyListService) receiveData(data []byte) {
-	if service.receiving == nil {
-		service.receiving = &PropertyListDatagram{}
+// closed reports ErrPropertyListServiceClosed to the handler the first time
+// the connection is found closed.
+func (service *PropertyListService) closed() {
+	service.closeOnce.Do(func() {
+		service.handler.plistError(ErrPropertyListServiceClosed)
+	})
+}
+
+// startTLS upgrades the connection in place, as lockdown expects after
+// StartSession replies with EnableSessionSSL. With handshakeOnly the stream
+// returns to plain text once the handshake completed.
+//
+// It blocks until the handshake is done or ctx ends, so it must not be called
+// from a PropertyListServiceClient callback.
+func (service *PropertyListService) startTLS(ctx context.Context, config *tls.Config, handshakeOnly bool) error {
+	service.streamMutex.Lock()
+	defer service.streamMutex.Unlock()
+
+	if service.stream != nil {
+		return fmt.Errorf("PropertyListService (%d) already upgraded", service.descriptor.port)
+	}
+
+	transport := service.device.adoptChannel(service.channel)
+	tlsConnection := tlsClient(transport, config, handshakeOnly)
+
+	stop := watchContext(ctx, transport)
+	err := tlsConnection.Handshake()
+	stop()
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
 
-		restruct.Unpack(data, binary.BigEndian, service.receiving)
-		service.receiving.Data = data[4:]
-		fmt.Printf("PropertyListService beginReceive got %d bytes of %d\n", len(service.receiving.Data), service.receiving.Length)
+	// The stream is read for as long as the service lives
+	transport.SetDeadline(time.Time{})
+	fmt.Printf("PropertyListService (%d) TLS handshake complete (handshake only %t)\n", service.descriptor.port, handshakeOnly)
+
+	if handshakeOnly {
+		service.stream = transport
 	} else {
-		service.receiving.Data = append(service.receiving.Data, data...)
-		fmt.Printf("PropertyListService continueReceive got %d new bytes (at %d of %d)\n", len(data), len(service.receiving.Data), service.receiving.Length)
+		service.stream = tlsConnection
 	}
 
-	if service.receiving != nil && service.receiving.Length == uint32(len(service.receiving.Data)) {
-		fmt.Printf("PropertyListService completeReceive with %d bytes\n", service.receiving.Length)
-		result := make(map[string]interface{})
+	go service.readStream(service.stream)
+
+	return nil
+}
 
-		_, err := plist.Unmarshal(service.receiving.Data, result)
+// readStream feeds the decoder from an upgraded stream until it ends.
+func (service *PropertyListService) readStream(stream io.Reader) {
+	buffer := make([]byte, ReadBufferSize*16)
+	for {
+		count, err := stream.Read(buffer)
+		if count > 0 {
+			service.receiveData(buffer[:count])
+		}
 		if err != nil {
-			fmt.Printf("PropertyListSerivce (%d) length %d unmarshal error\n", service.descriptor.port, len(data))
+			if err != io.EOF {
+				fmt.Printf("PropertyListService (%d) stream error %s\n", service.descriptor.port, err)
+				service.handler.plistError(err)
+			}
+			service.connectionStateChange(TCPStateClosed)
+			return
 		}
+	}
+}
+
+func (service *PropertyListService) receiveData(data []byte) {
+	messages, err := service.decoder.decode(data)
 
-		service.receiving = nil
+	for _, message := range messages {
+		fmt.Printf("PropertyListService (%d) received %d byte plist\n", service.descriptor.port, len(message))
+
+		// Unmarshal detects XML and binary plists by itself
+		var result interface{}
+		_, unmarshalErr := plist.Unmarshal(message, &result)
+		if unmarshalErr != nil {
+			fmt.Printf("PropertyListSerivce (%d) length %d unmarshal error %s\n", service.descriptor.port, len(message), unmarshalErr)
+			service.handler.plistError(unmarshalErr)
+			continue
+		}
 
 		service.handler.plistReceived(result)
 	}
+
+	if err != nil {
+		fmt.Printf("PropertyListService (%d) framing error %s\n", service.descriptor.port, err)
+		service.handler.plistError(err)
+		service.channel.Abort()
+	}
 }
 
 func (service *PropertyListService) sendPropertyList(data interface{}) {
-	if service.channel.state != TCPStateConnected {
-		fmt.Printf("Tried to send property list to service with state %d\n", service.channel.state)
+	if state := service.channel.currentState(); state != TCPStateConnected {
+		fmt.Printf("Tried to send property list to service with state %d\n", state)
 		return
 	}
 
-	rawData, err := plist.Marshal(data, plist.BinaryFormat)
+	datagramBytes, err := encodePropertyList(data)
 	if err != nil {
 		fmt.Printf("PropertyListService (%d) marshal error\n", service.descriptor.port)
 		return
 	}
 
+	service.streamMutex.Lock()
+	stream := service.stream
+	service.streamMutex.Unlock()
+
+	if stream == nil {
+		service.channel.send(datagramBytes)
+		return
+	}
+
+	if _, err = stream.Write(datagramBytes); err != nil {
+		fmt.Printf("PropertyListService (%d) stream write error %s\n", service.descriptor.port, err)
+	}
+}
+
+// encodePropertyList marshals data as a binary plist with its length prefix.
+func encodePropertyList(data interface{}) ([]byte, error) {
+	rawData, err := plist.Marshal(data, plist.BinaryFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	datagram := &PropertyListDatagram{
 		Length: uint32(len(rawData)),
 		Data:   rawData,
 	}
 
-	datagramBytes, _ := restruct.Pack(binary.BigEndian, datagram)
+	return restruct.Pack(binary.BigEndian, datagram)
+}
+
+// readPropertyList reads one length prefixed plist from a stream.
+func readPropertyList(reader io.Reader) ([]byte, error) {
+	lengthBytes := make([]byte, 4)
+	if _, err := io.ReadFull(reader, lengthBytes); err != nil {
+		return nil, err
+	}
+
+	length := binary.BigEndian.Uint32(lengthBytes)
+	if length > PropertyListMaxLength {
+		return nil, fmt.Errorf("plist length %d exceeds limit of %d", length, PropertyListMaxLength)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, err
+	}
 
-	service.channel.send(datagramBytes)
+	return data, nil
 }
 
 func (device *RemoteDevice) createService(descriptor PropertyListServiceDescriptor, handler PropertyListServiceClient) *PropertyListService {
 	service := &PropertyListService{
 		descriptor: descriptor,
+		device:     device,
 		handler:    handler,
+		decoder:    PropertyListDecoder{maxLength: PropertyListMaxLength},
 	}
 
 	service.channel = device.createTCPChannel(descriptor.port, service)
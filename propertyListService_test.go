@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"howett.net/plist"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPlistClient records what a PropertyListService hands to its client.
+type testPlistClient struct {
+	mutex       sync.Mutex
+	isConnected bool
+	received    []interface{}
+	errors      []error
+}
+
+func (client *testPlistClient) connected() {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.isConnected = true
+}
+
+func (client *testPlistClient) plistReceived(data interface{}) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.received = append(client.received, data)
+}
+
+func (client *testPlistClient) plistError(err error) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.errors = append(client.errors, err)
+}
+
+func TestPropertyListServiceReportsCloseOnce(t *testing.T) {
+	device, writer, _ := newTestDevice()
+	client := &testPlistClient{}
+
+	service := device.createService(PropertyListServiceDescriptor{port: 62078}, client)
+	deliver(t, device, service.channel, TCPHeaderFlagSYN|TCPHeaderFlagACK, 1)
+	if !client.isConnected {
+		t.Fatalf("client not told about the connection")
+	}
+
+	// The device closing makes the service close its side, which passes
+	// through CLOSE_WAIT, Closing and Closed
+	deliver(t, device, service.channel, TCPHeaderFlagFIN|TCPHeaderFlagACK, service.channel.txSequence)
+	last := writer.packets[len(writer.packets)-1]
+	if !last.header.hasFlag(TCPHeaderFlagFIN) {
+		t.Fatalf("expected the service to close its side")
+	}
+	deliver(t, device, service.channel, TCPHeaderFlagACK, service.channel.txSequence)
+	if state := service.channel.currentState(); state != TCPStateClosed {
+		t.Fatalf("expected closed, got state %d", state)
+	}
+
+	if len(client.errors) != 1 || !errors.Is(client.errors[0], ErrPropertyListServiceClosed) {
+		t.Fatalf("expected a single close error, got %v", client.errors)
+	}
+}
+
+// testServicePeer plays a device service behind a TCP channel: segments the
+// host sends come out of conn, and what is written to conn goes back to the host.
+type testServicePeer struct {
+	t      *testing.T
+	device *RemoteDevice
+
+	// Serialises packets into the device, which reads from a single goroutine
+	mutex           sync.Mutex
+	localPort       uint16
+	devicePort      uint16
+	sequence        uint32
+	acknowledgement uint32
+
+	conn net.Conn
+	pipe net.Conn
+}
+
+func newTestServicePeer(t *testing.T, device *RemoteDevice, queue chan *testPacket) *testServicePeer {
+	pipe, conn := net.Pipe()
+	peer := &testServicePeer{t: t, device: device, sequence: 5000, conn: conn, pipe: pipe}
+
+	go peer.fromHost(queue)
+	go peer.toHost()
+
+	return peer
+}
+
+func (peer *testServicePeer) close() {
+	peer.conn.Close()
+	peer.pipe.Close()
+}
+
+func (peer *testServicePeer) send(flags uint16, data []byte) {
+	peer.mutex.Lock()
+	defer peer.mutex.Unlock()
+
+	deliverSegment(peer.t, peer.device, peer.localPort, peer.devicePort, flags, peer.sequence, peer.acknowledgement, 0xFFFF, data)
+	peer.sequence += uint32(len(data))
+	if flags&TCPHeaderFlagSYN != 0 {
+		peer.sequence++
+	}
+}
+
+func (peer *testServicePeer) fromHost(queue chan *testPacket) {
+	for packet := range queue {
+		peer.mutex.Lock()
+		peer.localPort = packet.header.SourcePort
+		peer.devicePort = packet.header.DestinationPort
+		end := packet.header.Sequence + uint32(len(packet.data))
+		if packet.header.hasFlag(TCPHeaderFlagSYN) || packet.header.hasFlag(TCPHeaderFlagFIN) {
+			end++
+		}
+		if int32(end-peer.acknowledgement) > 0 {
+			peer.acknowledgement = end
+		}
+		peer.mutex.Unlock()
+
+		switch {
+		case packet.header.hasFlag(TCPHeaderFlagSYN):
+			peer.send(TCPHeaderFlagSYN|TCPHeaderFlagACK, nil)
+		case len(packet.data) > 0:
+			if _, err := peer.pipe.Write(packet.data); err != nil {
+				return
+			}
+			peer.send(TCPHeaderFlagACK, nil)
+		}
+	}
+}
+
+func (peer *testServicePeer) toHost() {
+	buffer := make([]byte, 16*1024)
+	for {
+		count, err := peer.pipe.Read(buffer)
+		if err != nil {
+			return
+		}
+		peer.send(TCPHeaderFlagACK, append([]byte{}, buffer[:count]...))
+	}
+}
+
+// testPairing returns a pair record and the matching TLS configuration of the device.
+func testPairing(t *testing.T) (*PairRecord, *tls.Config) {
+	deviceKey, err := rsa.GenerateKey(rand.Reader, PairingKeySize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record, err := generatePairRecord(pemEncode("RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&deviceKey.PublicKey)), "TEST")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certificate, err := tls.X509KeyPair(record.DeviceCertificate, pemEncode("RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(deviceKey)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return record, &tls.Config{Certificates: []tls.Certificate{certificate}, ClientAuth: tls.RequireAnyClientCert}
+}
+
+// received waits for the client to get a plist.
+func (client *testPlistClient) waitReceived(t *testing.T) interface{} {
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		client.mutex.Lock()
+		if len(client.received) > 0 {
+			received := client.received[0]
+			client.mutex.Unlock()
+			return received
+		}
+		client.mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("no plist received, errors %v", client.errors)
+	return nil
+}
+
+func testStartTLS(t *testing.T, handshakeOnly bool) {
+	record, serverConfig := testPairing(t)
+	clientConfig, err := record.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, writer, _ := newTestDevice()
+	writer.queue = make(chan *testPacket, 1024)
+	peer := newTestServicePeer(t, device, writer.queue)
+	defer peer.close()
+
+	// The device side answers one request, in plain text after the handshake
+	// when handshakeOnly is set
+	served := make(chan error, 1)
+	go func() {
+		server := tls.Server(peer.conn, serverConfig)
+		if err := server.Handshake(); err != nil {
+			served <- err
+			return
+		}
+
+		var stream io.ReadWriter = server
+		if handshakeOnly {
+			stream = peer.conn
+		}
+
+		data, err := readPropertyList(stream)
+		if err != nil {
+			served <- err
+			return
+		}
+		request := map[string]interface{}{}
+		if _, err = plist.Unmarshal(data, &request); err != nil {
+			served <- err
+			return
+		}
+
+		reply, _ := encodePropertyList(map[string]interface{}{"Reply": request["Request"]})
+		_, err = stream.Write(reply)
+		served <- err
+	}()
+
+	client := &testPlistClient{}
+	service := device.createService(PropertyListServiceDescriptor{port: 62078}, client)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for !client.isConnectedNow() {
+		if ctx.Err() != nil {
+			t.Fatalf("channel never connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = service.startTLS(ctx, clientConfig, handshakeOnly); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+
+	service.sendPropertyList(map[string]interface{}{"Request": "Hello"})
+	reply, _ := client.waitReceived(t).(map[string]interface{})
+	if reply["Reply"] != "Hello" {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+	if err = <-served; err != nil {
+		t.Fatalf("device side failed: %s", err)
+	}
+}
+
+func (client *testPlistClient) isConnectedNow() bool {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	return client.isConnected
+}
+
+func TestPropertyListServiceTLS(t *testing.T) {
+	testStartTLS(t, false)
+}
+
+func TestPropertyListServiceTLSHandshakeOnly(t *testing.T) {
+	testStartTLS(t, true)
+}
+
+func TestPropertyListServiceTLSHandshakeTimeout(t *testing.T) {
+	record, _ := testPairing(t)
+	clientConfig, err := record.tlsConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	device, writer, _ := newTestDevice()
+	writer.queue = make(chan *testPacket, 1024)
+	peer := newTestServicePeer(t, device, writer.queue)
+	defer peer.close()
+
+	// Nothing on the device side ever answers the ClientHello
+	go io.Copy(ioutil.Discard, peer.conn)
+
+	client := &testPlistClient{}
+	service := device.createService(PropertyListServiceDescriptor{port: 62078}, client)
+	for !client.isConnectedNow() {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- service.startTLS(ctx, clientConfig, false)
+	}()
+
+	select {
+	case err = <-done:
+		// The connection deadline may fire just before the context reports it
+		if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, os.ErrDeadlineExceeded) {
+			t.Fatalf("expected the deadline to end the handshake, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("handshake not bounded by the context")
+	}
+}
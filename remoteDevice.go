@@ -9,6 +9,7 @@ import (
 	"gopkg.in/restruct.v1"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -22,8 +23,9 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Maximum message size allowed from peer. A single transfer from the device
+	// may carry several full MUX frames.
+	maxMessageSize = 4 * MUXMaxFrameSize
 
 	messageTypeData = 1
 )
@@ -37,6 +39,18 @@ const (
 	MUXProtocolSetup   = 2
 	MUXProtocolTCP     = 6
 
+	// usbmuxd never puts more than this into a single MUX frame
+	MUXMaxFrameSize = 65536
+
+	// Range of local ports handed out to device TCP channels
+	MUXSourcePortFirst = 1024
+	MUXSourcePortLast  = 0xFFFF
+
+	// Interface usbmuxd talks to on the device
+	USBMuxInterfaceClass    = 0xFF
+	USBMuxInterfaceSubclass = 0xFE
+	USBMuxInterfaceProtocol = 2
+
 	MUXProtocolResultError   = 0x03
 	MUXProtocolResultWarning = 0x05
 	MUXProtocolResultInfo    = 0x07
@@ -71,11 +85,21 @@ type RemoteConnection struct {
 
 	open bool
 
+	// Serialises writes to connection, which allows one writer at a time, from
+	// writePump and every device on the connection
+	writeMutex sync.Mutex
+
 	send chan *ClientMessage
 
 	close chan bool
 }
 
+// messageWriter takes the encoded messages for a device, the remote connection
+// unless a test substitutes its own.
+type messageWriter interface {
+	WriteMessage(messageType int, data []byte) error
+}
+
 // Client is a middleman between the websocket connection and the hub.
 type RemoteDevice struct {
 	// Associated hub
@@ -84,6 +108,8 @@ type RemoteDevice struct {
 	// Associated Connection
 	connection *RemoteConnection
 
+	writer messageWriter
+
 	// Device serial number
 	serialNumber string
 
@@ -91,15 +117,47 @@ type RemoteDevice struct {
 
 	connectedMessage *DeviceConnected
 
+	// Open TCP channels keyed by our local source port
 	channels map[uint16]*TCPChannel
 
+	channelsMutex sync.Mutex
+
+	// Keeps MUX packets from channels sending on different goroutines in
+	// sequence order. The connection serialises the actual writes.
+	sendMutex sync.Mutex
+
 	transmitSequence uint16
 	receiveSequence  uint16
 	sourcePort       uint16
-	LockdownService  *LockdownService
+
+	// Largest TCP payload put into one MUX frame for this device
+	segmentSize int
+
+	// Max packet size of the device's bulk OUT endpoint
+	usbPacketSize int
+
+	// Timers applied to this device's channels
+	tcpConfig TCPChannelConfig
+
+	LockdownService *LockdownService
+	lockdownMutex   sync.Mutex
+
+	// Held while pairing so only one trust dialog shows at a time
+	pairingMutex sync.Mutex
+
+	// Values queried from lockdown after attaching
+	info      DeviceInfo
+	infoMutex sync.Mutex
+
+	syslogRelay *SyslogRelay
+
+	notificationProxy *NotificationProxy
 }
 
 func (device *RemoteDevice) sendPacket(packetProtocol int, data []byte) {
+	device.sendMutex.Lock()
+	defer device.sendMutex.Unlock()
+
 	if packetProtocol == MUXProtocolSetup {
 		device.receiveSequence = 0xFFFF
 		device.transmitSequence = 0x0000
@@ -143,7 +201,7 @@ func (device *RemoteDevice) sendPacket(packetProtocol int, data []byte) {
 		fmt.Printf("RemoteDevice error marshalling client message %s\n", err)
 	}
 
-	err = device.connection.connection.WriteMessage(websocket.BinaryMessage, messageData)
+	err = device.writer.WriteMessage(websocket.BinaryMessage, messageData)
 	if err != nil {
 		fmt.Printf("RemoteDevice WriteMessage error %s\n", err)
 	}
@@ -160,6 +218,7 @@ func (remote *RemoteConnection) readPump() {
 	}
 
 	defer remote.connection.Close()
+	defer remote.cleanupConnection()
 
 	remote.connection.SetReadLimit(maxMessageSize)
 	remote.connection.SetReadDeadline(time.Now().Add(pongWait))
@@ -189,21 +248,41 @@ func (remote *RemoteConnection) readPump() {
 			deviceConnectedMessage := serverMessage.GetDeviceConnected()
 			fmt.Printf("Device Connected %s\n", deviceConnectedMessage.SerialNumber)
 			device := &RemoteDevice{
-				sourcePort:       1024,
+				sourcePort:       MUXSourcePortFirst,
+				segmentSize:      *segmentSizeFlag,
+				usbPacketSize:    usbPacketSize(deviceConnectedMessage.Device),
+				tcpConfig:        defaultChannelConfig(),
 				hub:              remote.hub,
 				connection:       remote,
+				writer:           remote,
 				serialNumber:     deviceConnectedMessage.SerialNumber,
 				connectedMessage: deviceConnectedMessage,
 				channels:         make(map[uint16]*TCPChannel),
 			}
+			device.syslogRelay = newSyslogRelay(device)
+			device.notificationProxy = newNotificationProxy(device)
 
+			remote.hub.devicesMutex.Lock()
 			remote.hub.devices[deviceConnectedMessage.SerialNumber] = device
+			remote.hub.devicesMutex.Unlock()
+			remote.devices[device] = true
+
+			remote.hub.events <- &DeviceEvent{Type: DeviceEventAttached, Device: device.serialNumber}
 			device.sendVersion()
 
 		case *ServerMessage_FromDevice:
 			fromDeviceMessage := serverMessage.GetFromDevice()
 			fmt.Printf("Got %d bytes of data from device %s\n", len(fromDeviceMessage.Data), fromDeviceMessage.SerialNumber)
-			remote.hub.devices[fromDeviceMessage.SerialNumber].receiveData(fromDeviceMessage.Data)
+			remote.hub.devicesMutex.RLock()
+			device := remote.hub.devices[fromDeviceMessage.SerialNumber]
+			remote.hub.devicesMutex.RUnlock()
+
+			// Data can still arrive for a device that was already removed
+			if device == nil {
+				fmt.Printf("Dropping data for unknown device %s\n", fromDeviceMessage.SerialNumber)
+				continue
+			}
+			device.receiveData(fromDeviceMessage.Data)
 
 		case *ServerMessage_ToDeviceResult:
 			toDeviceResult := serverMessage.GetToDeviceResult()
@@ -249,7 +328,8 @@ func (device *RemoteDevice) receiveData(data []byte) {
 			device.versionHeader = &versionHeader
 			device.sendPacket(MUXProtocolSetup, []byte{0x05})
 
-			device.LockdownService = device.createLockdownService()
+			device.lockdown()
+			go device.loadInfo()
 		}
 	case MUXProtocolControl:
 		controlData := data[USBMuxDHeaderSize+1 : muxHeader.Length]
@@ -273,7 +353,11 @@ func (device *RemoteDevice) receiveData(data []byte) {
 			return
 		}
 
-		channel := device.channels[tcpHeader.SourcePort]
+		// The device's destination port is the local port we opened the channel from
+		device.channelsMutex.Lock()
+		channel := device.channels[tcpHeader.DestinationPort]
+		device.channelsMutex.Unlock()
+
 		if channel == nil {
 			fmt.Printf("Could not find an active channle for src %d and dst %d\n", tcpHeader.SourcePort, tcpHeader.DestinationPort)
 		} else {
@@ -306,19 +390,49 @@ func (device *RemoteDevice) sendVersion() {
 
 func (remote *RemoteConnection) cleanupConnection() {
 	remote.close <- true
-	remote.hub.remoteConnections[remote] = false
+
+	// remoteConnections belongs to the hub's goroutine
+	remote.hub.remoteDisconnected <- remote
 
 	for device := range remote.devices {
-		// TODO: Notify devices are not available
+		remote.hub.devicesMutex.Lock()
 		remote.hub.devices[device.serialNumber] = nil
+		remote.hub.devicesMutex.Unlock()
+
+		device.detach()
+		remote.hub.events <- &DeviceEvent{Type: DeviceEventRemoved, Device: device.serialNumber}
+	}
+}
+
+// detach ends everything still using a device that went away. Its channels are
+// aborted, which wakes calls blocked on them, and its lockdown client is closed.
+func (device *RemoteDevice) detach() {
+	device.channelsMutex.Lock()
+	channels := make([]*TCPChannel, 0, len(device.channels))
+	for _, channel := range device.channels {
+		channels = append(channels, channel)
+	}
+	device.channelsMutex.Unlock()
+
+	// Closed channels remove themselves, so abort outside channelsMutex
+	for _, channel := range channels {
+		channel.Abort()
 	}
+
+	device.lockdownMutex.Lock()
+	if device.LockdownService != nil {
+		device.LockdownService.close(ErrLockdownClosed)
+	}
+	device.lockdownMutex.Unlock()
+
+	fmt.Printf("RemoteDevice %s detached, aborted %d channels\n", device.serialNumber, len(channels))
 }
 
 // writePump pumps messages from the hub to the websocket connection.
 //
-// A goroutine running writePump is started for each connection. The
-// application ensures that there is at most one writer to a connection by
-// executing all writes from this goroutine.
+// A goroutine running writePump is started for each connection. Devices on the
+// connection write their packets directly, so every write goes through
+// WriteMessage to keep to one writer at a time.
 func (remote *RemoteConnection) writePump() {
 	ticker := time.NewTicker(pingPeriod)
 	defer ticker.Stop()
@@ -327,21 +441,13 @@ func (remote *RemoteConnection) writePump() {
 	for {
 		select {
 		case message := <-remote.send:
-			writer, err := remote.connection.NextWriter(messageTypeData)
+			data, err := proto.Marshal(message)
 			if err != nil {
-				fmt.Printf("ClientMessage NextWriter Error: %s\n", err)
+				fmt.Printf("ClientMessage Marshal Error: %s\n", err)
+			} else if err = remote.WriteMessage(messageTypeData, data); err != nil {
+				fmt.Printf("ClientMessage Write Error: %s\n", err)
 			} else {
-				data, err := proto.Marshal(message)
-				if err != nil {
-					fmt.Printf("ClientMessage Marshal Error: %s\n", err)
-				} else {
-					count, err := writer.Write(data)
-					if err != nil {
-						fmt.Printf("ClientMessage Write Error: %s", err)
-					} else {
-						fmt.Printf("ClientMessage Wrote %d bytes", count)
-					}
-				}
+				fmt.Printf("ClientMessage Wrote %d bytes\n", len(data))
 			}
 		case <-remote.close:
 			remote.open = false
@@ -350,18 +456,116 @@ func (remote *RemoteConnection) writePump() {
 	}
 }
 
+// WriteMessage writes one message to the websocket connection.
+func (remote *RemoteConnection) WriteMessage(messageType int, data []byte) error {
+	remote.writeMutex.Lock()
+	defer remote.writeMutex.Unlock()
+
+	remote.connection.SetWriteDeadline(time.Now().Add(writeWait))
+	return remote.connection.WriteMessage(messageType, data)
+}
+
+// createTCPChannel connects to port on the device. It returns nil if every local
+// source port is taken by an open channel.
 func (device *RemoteDevice) createTCPChannel(port uint16, handler TCPChannelHandler) *TCPChannel {
-	channel := createChannel(device.sourcePort, port, device, handler)
-	device.sourcePort++
-	device.channels[port] = channel
+	// Hold the lock while the SYN goes out so the reply can't race the insert
+	device.channelsMutex.Lock()
+	defer device.channelsMutex.Unlock()
+
+	sourcePort, ok := device.allocateSourcePort()
+	if !ok {
+		fmt.Printf("RemoteDevice %s has no free source port for dstPort %d\n", device.serialNumber, port)
+		return nil
+	}
+
+	channel := createChannel(sourcePort, port, device, handler)
+	device.channels[sourcePort] = channel
 
 	return channel
 }
 
+// allocateSourcePort returns the next local port not used by an open channel,
+// wrapping around at the end of the range so closed channels' ports get reused.
+// The caller holds channelsMutex.
+func (device *RemoteDevice) allocateSourcePort() (uint16, bool) {
+	for attempt := 0; attempt <= MUXSourcePortLast-MUXSourcePortFirst; attempt++ {
+		port := device.sourcePort
+		if device.sourcePort == MUXSourcePortLast {
+			device.sourcePort = MUXSourcePortFirst
+		} else {
+			device.sourcePort++
+		}
+
+		if _, used := device.channels[port]; !used {
+			return port, true
+		}
+	}
+
+	return 0, false
+}
+
+func (device *RemoteDevice) channelConfig() *TCPChannelConfig {
+	return &device.tcpConfig
+}
+
+// channelClosed releases the source port of a channel that has shut down.
+func (device *RemoteDevice) channelClosed(channel *TCPChannel) {
+	device.channelsMutex.Lock()
+	defer device.channelsMutex.Unlock()
+
+	if device.channels[channel.sourcePort] == channel {
+		delete(device.channels, channel.sourcePort)
+	}
+}
+
 func (device *RemoteDevice) sendTCPData(data []byte) {
 	device.sendPacket(MUXProtocolTCP, data)
 }
 
+// maxSegmentSize returns the largest payload a TCPChannel may put in one frame.
+//
+// The size is trimmed so that a full frame never ends exactly on a USB packet
+// boundary, which would need a zero length packet to terminate the transfer.
+func (device *RemoteDevice) maxSegmentSize() int {
+	size := device.segmentSize
+	if size <= 0 || size > MUXMaxFrameSize-USBMuxDHeaderSize-TCPHeaderSize {
+		size = MUXMaxFrameSize - USBMuxDHeaderSize - TCPHeaderSize
+	}
+
+	if device.usbPacketSize > 0 && (USBMuxDHeaderSize+TCPHeaderSize+size)%device.usbPacketSize == 0 {
+		size--
+	}
+
+	return size
+}
+
+// usbPacketSize returns the max packet size of the bulk OUT endpoint on the
+// device's usbmux interface, falling back to the command line value for clients
+// that don't send descriptors.
+func usbPacketSize(descriptor *USBDevice) int {
+	for _, configuration := range descriptor.GetConfigurations() {
+		if descriptor.SelectedConfiguration != 0 && configuration.ConfigurationValue != descriptor.SelectedConfiguration {
+			continue
+		}
+
+		for _, usbInterface := range configuration.Interfaces {
+			for _, alternate := range usbInterface.Alternates {
+				if alternate.InterfaceClass != USBMuxInterfaceClass || alternate.InterfaceSubclass != USBMuxInterfaceSubclass || alternate.InterfaceProtocol != USBMuxInterfaceProtocol {
+					continue
+				}
+
+				for _, endpoint := range alternate.Endpoints {
+					if endpoint.Direction == USBDirection_OUT && endpoint.Type == USBEndpointType_BULK && endpoint.PacketSize > 0 {
+						return int(endpoint.PacketSize)
+					}
+				}
+			}
+		}
+	}
+
+	return *usbPacketSizeFlag
+}
+
 func (hub *Hub) makeRemoteConnection(wsConnection *websocket.Conn) *RemoteConnection {
 	remoteConnection := &RemoteConnection{
 		hub:        hub,
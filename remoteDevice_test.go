@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/binary"
+	"github.com/gorilla/websocket"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/restruct.v1"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUSBPacketSize(t *testing.T) {
+	descriptor := &USBDevice{
+		SelectedConfiguration: 4,
+		Configurations: []*USBConfiguration{
+			{
+				ConfigurationValue: 1,
+				Interfaces: []*USBInterface{{Alternates: []*USBAlternateInterface{{
+					InterfaceClass:    USBMuxInterfaceClass,
+					InterfaceSubclass: USBMuxInterfaceSubclass,
+					InterfaceProtocol: USBMuxInterfaceProtocol,
+					Endpoints:         []*USBEndpoint{{Direction: USBDirection_OUT, Type: USBEndpointType_BULK, PacketSize: 64}},
+				}}}},
+			},
+			{
+				ConfigurationValue: 4,
+				Interfaces: []*USBInterface{
+					// PTP
+					{Alternates: []*USBAlternateInterface{{
+						InterfaceClass: 6,
+						Endpoints:      []*USBEndpoint{{Direction: USBDirection_OUT, Type: USBEndpointType_BULK, PacketSize: 256}},
+					}}},
+					{Alternates: []*USBAlternateInterface{{
+						InterfaceClass:    USBMuxInterfaceClass,
+						InterfaceSubclass: USBMuxInterfaceSubclass,
+						InterfaceProtocol: USBMuxInterfaceProtocol,
+						Endpoints: []*USBEndpoint{
+							{Direction: USBDirection_IN, Type: USBEndpointType_BULK, PacketSize: 512},
+							{Direction: USBDirection_OUT, Type: USBEndpointType_BULK, PacketSize: 1024},
+						},
+					}}},
+				},
+			},
+		},
+	}
+
+	if size := usbPacketSize(descriptor); size != 1024 {
+		t.Errorf("expected the OUT endpoint of the selected configuration, got %d", size)
+	}
+	if size := usbPacketSize(nil); size != *usbPacketSizeFlag {
+		t.Errorf("expected the default without descriptors, got %d", size)
+	}
+}
+
+func TestMaxSegmentSizeAvoidsZeroLengthPackets(t *testing.T) {
+	for _, packetSize := range []int{64, 512, 1024} {
+		device := &RemoteDevice{segmentSize: 8*packetSize - USBMuxDHeaderSize - TCPHeaderSize, usbPacketSize: packetSize}
+
+		size := device.maxSegmentSize()
+		if size != device.segmentSize-1 {
+			t.Errorf("packet size %d: expected %d, got %d", packetSize, device.segmentSize-1, size)
+		}
+		if (USBMuxDHeaderSize+TCPHeaderSize+size)%packetSize == 0 {
+			t.Errorf("packet size %d: frame of %d bytes ends on a packet boundary", packetSize, size)
+		}
+	}
+}
+
+// testWriter stands in for the websocket, decoding the TCP packets a device sends.
+type testWriter struct {
+	mutex   sync.Mutex
+	packets []*testPacket
+
+	// When set, packets are also handed to a simulated device through it
+	queue chan *testPacket
+}
+
+func (writer *testWriter) WriteMessage(messageType int, data []byte) error {
+	message := &ClientMessage{}
+	if err := proto.Unmarshal(data, message); err != nil {
+		return err
+	}
+
+	frame := message.GetToDevice().GetData()
+	header := &MUXHeader{}
+	if err := restruct.Unpack(frame[:USBMuxDHeaderSize], binary.BigEndian, header); err != nil {
+		return err
+	}
+	if header.Protocol != MUXProtocolTCP {
+		return nil
+	}
+
+	packet := decodeTestPacket(frame[USBMuxDHeaderSize:])
+	if writer.queue != nil {
+		writer.queue <- packet
+	}
+
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.packets = append(writer.packets, packet)
+	return nil
+}
+
+func newTestDevice() (*RemoteDevice, *testWriter, *manualClock) {
+	writer := &testWriter{}
+	clock := newManualClock()
+	device := &RemoteDevice{
+		serialNumber:  "test",
+		writer:        writer,
+		sourcePort:    MUXSourcePortFirst,
+		segmentSize:   *segmentSizeFlag,
+		usbPacketSize: 512,
+		tcpConfig:     TCPChannelConfig{clock: clock},
+		channels:      make(map[uint16]*TCPChannel),
+	}
+	return device, writer, clock
+}
+
+// deliver feeds a TCP packet for channel to the device as if the device sent it.
+func deliver(t *testing.T, device *RemoteDevice, channel *TCPChannel, flags uint16, ack uint32) {
+	deliverSegment(t, device, channel.sourcePort, channel.destinationPort, flags, 0, ack, 0xFFFF, nil)
+}
+
+// deliverSegment feeds a TCP packet with data from the device's port to our
+// local port, advertising window (unscaled) as the device's receive window.
+func deliverSegment(t *testing.T, device *RemoteDevice, localPort uint16, devicePort uint16, flags uint16, sequence uint32, ack uint32, window uint16, data []byte) {
+	tcpHeader, err := restruct.Pack(binary.BigEndian, &TCPHeader{
+		SourcePort:      devicePort,
+		DestinationPort: localPort,
+		Sequence:        sequence,
+		Acknowledgement: ack,
+		OffsetFlags:     flags | TCPOffset,
+		Window:          window,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	muxHeader, err := restruct.Pack(binary.BigEndian, &MUXHeader{
+		Protocol: MUXProtocolTCP,
+		Length:   uint32(USBMuxDHeaderSize + len(tcpHeader) + len(data)),
+		Magic:    MUXProtocolReceiveMagic,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	frame := append(muxHeader, tcpHeader...)
+	device.receiveData(append(frame, data...))
+}
+
+func TestConcurrentChannelsToOnePort(t *testing.T) {
+	device, _, _ := newTestDevice()
+
+	const sessions = 200
+	channels := make(chan *TCPChannel, sessions)
+	handlers := make(map[*TCPChannel]*testHandler)
+	var handlersMutex sync.Mutex
+
+	var group sync.WaitGroup
+	for i := 0; i < sessions; i++ {
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			handler := &testHandler{}
+			channel := device.createTCPChannel(62078, handler)
+			handlersMutex.Lock()
+			handlers[channel] = handler
+			handlersMutex.Unlock()
+			channels <- channel
+		}()
+	}
+	group.Wait()
+	close(channels)
+
+	ports := make(map[uint16]bool)
+	for channel := range channels {
+		if ports[channel.sourcePort] {
+			t.Fatalf("source port %d handed out twice", channel.sourcePort)
+		}
+		ports[channel.sourcePort] = true
+
+		// Replies are routed by our source port, not the shared device port
+		deliver(t, device, channel, TCPHeaderFlagSYN|TCPHeaderFlagACK, 1)
+		if state := handlers[channel].lastState(); state != TCPStateConnected {
+			t.Fatalf("channel %d in state %d after its SYN|ACK", channel.sourcePort, state)
+		}
+	}
+
+	if len(device.channels) != sessions {
+		t.Fatalf("expected %d open channels, got %d", sessions, len(device.channels))
+	}
+}
+
+func TestSourcePortWrapsAround(t *testing.T) {
+	device, _, _ := newTestDevice()
+
+	first := device.createTCPChannel(62078, &testHandler{})
+	if first.sourcePort != MUXSourcePortFirst {
+		t.Fatalf("expected the first port of the range, got %d", first.sourcePort)
+	}
+
+	device.sourcePort = MUXSourcePortLast - 1
+	var ports []uint16
+	for i := 0; i < 3; i++ {
+		ports = append(ports, device.createTCPChannel(62078, &testHandler{}).sourcePort)
+	}
+
+	// The port still held by the first channel is skipped after wrapping
+	expected := []uint16{MUXSourcePortLast - 1, MUXSourcePortLast, MUXSourcePortFirst + 1}
+	for i := range expected {
+		if ports[i] != expected[i] {
+			t.Fatalf("expected ports %v, got %v", expected, ports)
+		}
+	}
+}
+
+func TestSourcePortReusedAfterTimeWait(t *testing.T) {
+	device, _, clock := newTestDevice()
+
+	channel := device.createTCPChannel(62078, &testHandler{})
+	port := channel.sourcePort
+	deliver(t, device, channel, TCPHeaderFlagSYN|TCPHeaderFlagACK, 1)
+
+	// We close first, so we are the side that lingers in TIME_WAIT
+	channel.Close()
+	deliver(t, device, channel, TCPHeaderFlagACK, channel.txSequence)
+	deliver(t, device, channel, TCPHeaderFlagFIN|TCPHeaderFlagACK, channel.txSequence)
+	if state := channel.currentState(); state != TCPStateTimeWait {
+		t.Fatalf("expected TIME_WAIT, got state %d", state)
+	}
+
+	device.sourcePort = port
+	if next := device.createTCPChannel(62078, &testHandler{}); next.sourcePort == port {
+		t.Fatalf("port %d reused while in TIME_WAIT", port)
+	}
+
+	clock.Advance(TCPTimeWaitDuration)
+	if state := channel.currentState(); state != TCPStateClosed {
+		t.Fatalf("expected closed after TIME_WAIT, got state %d", state)
+	}
+
+	device.sourcePort = port
+	if next := device.createTCPChannel(62078, &testHandler{}); next.sourcePort != port {
+		t.Fatalf("expected port %d to be reused after TIME_WAIT, got %d", port, next.sourcePort)
+	}
+}
+
+func TestDevicesShareConnectionWrites(t *testing.T) {
+	connections := make(chan *websocket.Conn, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		upgrader := websocket.Upgrader{}
+		connection, err := upgrader.Upgrade(writer, request, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		connections <- connection
+	}))
+	defer server.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	remote := &RemoteConnection{connection: <-connections}
+	defer remote.connection.Close()
+
+	const devices = 4
+	const packets = 50
+	var group sync.WaitGroup
+	for i := 0; i < devices; i++ {
+		device := &RemoteDevice{serialNumber: string(rune('a' + i)), connection: remote, writer: remote}
+		group.Add(1)
+		go func() {
+			defer group.Done()
+			for j := 0; j < packets; j++ {
+				device.sendTCPData(make([]byte, 100))
+			}
+		}()
+	}
+
+	// Every message arrives whole, in order per device
+	sequences := make(map[string]uint16)
+	for i := 0; i < devices*packets; i++ {
+		_, data, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message := &ClientMessage{}
+		if err = proto.Unmarshal(data, message); err != nil {
+			t.Fatal(err)
+		}
+		toDevice := message.GetToDevice()
+		header := &MUXHeader{}
+		if err = restruct.Unpack(toDevice.GetData()[:USBMuxDHeaderSize], binary.BigEndian, header); err != nil {
+			t.Fatal(err)
+		}
+		if header.Length != USBMuxDHeaderSize+100 || header.TransmitSequence != sequences[toDevice.SerialNumber] {
+			t.Fatalf("device %s packet %d arrived as length %d sequence %d", toDevice.SerialNumber, sequences[toDevice.SerialNumber], header.Length, header.TransmitSequence)
+		}
+		sequences[toDevice.SerialNumber]++
+	}
+	group.Wait()
+}
+
+func TestDetachWakesBlockedRead(t *testing.T) {
+	device, _, conn := newTestDeviceConn(t)
+	device.LockdownService = &LockdownService{ready: make(chan struct{}), done: make(chan struct{})}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(make([]byte, 16))
+		result <- err
+	}()
+
+	// Let the read block first
+	time.Sleep(20 * time.Millisecond)
+	device.detach()
+
+	select {
+	case err := <-result:
+		if err != io.EOF {
+			t.Fatalf("expected io.EOF once the device is gone, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("read still blocked after the device was removed")
+	}
+
+	if len(device.channels) != 0 {
+		t.Fatalf("expected the channels to be released, %d left", len(device.channels))
+	}
+	if !device.LockdownService.closed() {
+		t.Fatalf("lockdown client left open")
+	}
+}
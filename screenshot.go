@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"golang.org/x/image/tiff"
+	"image/png"
+	"net/http"
+	"time"
+)
+
+const ScreenshotServiceName = "com.apple.mobile.screenshotr"
+
+// DeviceLink major version screenshotr speaks
+const ScreenshotDeviceLinkVersion = 300
+
+// Frame interval of the websocket stream when none is requested, and the fastest allowed
+const (
+	ScreenshotStreamInterval    = time.Second
+	ScreenshotStreamMinInterval = 100 * time.Millisecond
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+type ScreenshotClient struct {
+	link *DeviceLinkService
+}
+
+func (device *RemoteDevice) OpenScreenshot(ctx context.Context) (*ScreenshotClient, error) {
+	link, err := device.OpenDeviceLink(ctx, ScreenshotServiceName, ScreenshotDeviceLinkVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ScreenshotClient{link: link}, nil
+}
+
+func (client *ScreenshotClient) Close() error {
+	return client.link.Disconnect(context.Background(), "___EmptyParameterString___")
+}
+
+// Capture takes a screenshot and returns it as PNG. Older devices answer with a
+// TIFF, which is converted.
+func (client *ScreenshotClient) Capture(ctx context.Context) ([]byte, error) {
+	err := client.link.SendProcessMessage(ctx, map[string]interface{}{"MessageType": "ScreenShotRequest"})
+	if err != nil {
+		return nil, err
+	}
+
+	reply, err := client.link.ReceiveProcessMessage(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if messageType, _ := reply["MessageType"].(string); messageType != "ScreenShotReply" {
+		return nil, fmt.Errorf("screenshotr unexpected reply %s", messageType)
+	}
+	data, ok := reply["ScreenShotData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("screenshotr reply without data")
+	}
+
+	return convertScreenshot(data)
+}
+
+// convertScreenshot returns data as PNG, decoding it as TIFF unless it already is one.
+func convertScreenshot(data []byte) ([]byte, error) {
+	if bytes.HasPrefix(data, pngSignature) {
+		return data, nil
+	}
+
+	image, err := tiff.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("screenshot: %w", err)
+	}
+
+	buffer := &bytes.Buffer{}
+	if err = png.Encode(buffer, image); err != nil {
+		return nil, err
+	}
+
+	return buffer.Bytes(), nil
+}
+
+// handleScreenshot serves /v1/devices/{id}/screenshot as a PNG or, when
+// upgraded, as a websocket sending a PNG frame every interval (?interval=500ms).
+func handleScreenshot(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	interval := ScreenshotStreamInterval
+	if value := request.URL.Query().Get("interval"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err)
+			return
+		}
+		if parsed < ScreenshotStreamMinInterval {
+			parsed = ScreenshotStreamMinInterval
+		}
+		interval = parsed
+	}
+
+	client, err := device.OpenScreenshot(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	if websocket.IsWebSocketUpgrade(request) {
+		streamScreenshotWebsocket(device, writer, request, client, interval)
+		return
+	}
+
+	data, err := client.Capture(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "image/png")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(data)
+}
+
+func streamScreenshotWebsocket(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, client *ScreenshotClient, interval time.Duration) {
+	connection, err := device.hub.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		fmt.Printf("Screenshot websocket upgrade error %s\n", err)
+		return
+	}
+	defer connection.Close()
+
+	ctx, cancel := context.WithCancel(request.Context())
+	defer cancel()
+
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		data, err := client.Capture(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				fmt.Printf("Screenshot capture error %s\n", err)
+			}
+			return
+		}
+
+		connection.SetWriteDeadline(time.Now().Add(writeWait))
+		if err = connection.WriteMessage(websocket.BinaryMessage, data); err != nil {
+			return
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"howett.net/plist"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+const SpringBoardServiceName = "com.apple.springboardservices"
+
+// Icon state format returning folders and the dock as nested arrays
+const SpringBoardIconStateFormat = "2"
+
+// Largest icon state accepted from API clients
+const springBoardMaxIconStateSize = 4 * 1024 * 1024
+
+// Media type of icon states exchanged as property lists, keeping the types
+// JSON can't tell apart
+const springBoardPlistMediaType = "application/x-plist"
+
+// Interface orientations reported by getInterfaceOrientation
+var springBoardOrientations = map[uint64]string{
+	0: "unknown",
+	1: "portrait",
+	2: "portraitUpsideDown",
+	3: "landscapeRight",
+	4: "landscapeLeft",
+}
+
+type SpringBoardRequest struct {
+	Command       string      `plist:"command"`
+	FormatVersion string      `plist:"formatVersion,omitempty"`
+	BundleID      string      `plist:"bundleId,omitempty"`
+	IconState     interface{} `plist:"iconState,omitempty"`
+}
+
+type SpringBoardReply struct {
+	PNGData              []byte `plist:"pngData"`
+	InterfaceOrientation uint64 `plist:"interfaceOrientation"`
+}
+
+type SpringBoardClient struct {
+	service *ServiceConnection
+}
+
+func (device *RemoteDevice) OpenSpringBoard(ctx context.Context) (*SpringBoardClient, error) {
+	service, err := device.OpenService(ctx, SpringBoardServiceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SpringBoardClient{service: service}, nil
+}
+
+func (client *SpringBoardClient) Close() error {
+	return client.service.Close()
+}
+
+// IconState returns the home screen layout, an array of pages with the dock first.
+func (client *SpringBoardClient) IconState(ctx context.Context) (interface{}, error) {
+	var state interface{}
+	err := client.service.Call(ctx, &SpringBoardRequest{Command: "getIconState", FormatVersion: SpringBoardIconStateFormat}, &state)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := state.([]interface{}); !ok {
+		return nil, fmt.Errorf("springboardservices unexpected icon state %T", state)
+	}
+	return state, nil
+}
+
+// SetIconState replaces the home screen layout. The device does not reply.
+func (client *SpringBoardClient) SetIconState(ctx context.Context, state interface{}) error {
+	return client.service.Send(ctx, &SpringBoardRequest{Command: "setIconState", IconState: state})
+}
+
+// IconPNG returns the icon of the app with bundleID.
+func (client *SpringBoardClient) IconPNG(ctx context.Context, bundleID string) ([]byte, error) {
+	return client.pngData(ctx, &SpringBoardRequest{Command: "getIconPNGData", BundleID: bundleID})
+}
+
+func (client *SpringBoardClient) WallpaperPNG(ctx context.Context) ([]byte, error) {
+	return client.pngData(ctx, &SpringBoardRequest{Command: "getHomeScreenWallpaperPNGData"})
+}
+
+func (client *SpringBoardClient) pngData(ctx context.Context, request *SpringBoardRequest) ([]byte, error) {
+	reply := &SpringBoardReply{}
+	if err := client.service.Call(ctx, request, reply); err != nil {
+		return nil, err
+	}
+
+	if len(reply.PNGData) == 0 {
+		return nil, fmt.Errorf("springboardservices %s returned no image", request.Command)
+	}
+	return reply.PNGData, nil
+}
+
+// InterfaceOrientation returns the orientation as one of springBoardOrientations' values.
+func (client *SpringBoardClient) InterfaceOrientation(ctx context.Context) (uint64, error) {
+	reply := &SpringBoardReply{}
+	if err := client.service.Call(ctx, &SpringBoardRequest{Command: "getInterfaceOrientation"}, reply); err != nil {
+		return 0, err
+	}
+
+	return reply.InterfaceOrientation, nil
+}
+
+// handleSpringBoard serves the springboardservices endpoints:
+//
+//	GET /v1/devices/{id}/springboard/icons
+//	PUT /v1/devices/{id}/springboard/icons      (body is an icon state as returned by GET)
+//
+// Icon states are JSON unless requested (Accept) or sent (Content-Type) as
+// application/x-plist. Only a plist state is put back exactly as read, JSON
+// loses dates and data and turns whole reals into integers.
+//
+//	GET /v1/devices/{id}/springboard/icons/{bundleID}
+//	GET /v1/devices/{id}/springboard/wallpaper
+//	GET /v1/devices/{id}/springboard/orientation
+func handleSpringBoard(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	var state interface{}
+	if request.Method == http.MethodPut {
+		if path != "icons" {
+			writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on springboard/%s", request.Method, path))
+			return
+		}
+
+		var err error
+		state, err = decodeIconState(writer, request)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err)
+			return
+		}
+		if _, ok := state.([]interface{}); !ok {
+			writeError(writer, http.StatusBadRequest, fmt.Errorf("icon state must be an array"))
+			return
+		}
+	} else if request.Method != http.MethodGet {
+		writeError(writer, http.StatusMethodNotAllowed, fmt.Errorf("method %s not allowed on springboard/%s", request.Method, path))
+		return
+	}
+
+	client, err := device.OpenSpringBoard(request.Context())
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer client.Close()
+
+	var image []byte
+	switch {
+	case path == "icons" && request.Method == http.MethodPut:
+		if err = client.SetIconState(request.Context(), state); err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		writeJSON(writer, http.StatusOK, map[string]string{"status": "Updated"})
+		return
+
+	case path == "icons":
+		state, err = client.IconState(request.Context())
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		if !strings.Contains(request.Header.Get("Accept"), springBoardPlistMediaType) {
+			writeJSON(writer, http.StatusOK, state)
+			return
+		}
+
+		data, err := plist.Marshal(state, plist.XMLFormat)
+		if err != nil {
+			writeError(writer, http.StatusInternalServerError, err)
+			return
+		}
+		writer.Header().Set("Content-Type", springBoardPlistMediaType)
+		writer.WriteHeader(http.StatusOK)
+		writer.Write(data)
+		return
+
+	case strings.HasPrefix(path, "icons/"):
+		image, err = client.IconPNG(request.Context(), strings.TrimPrefix(path, "icons/"))
+
+	case path == "wallpaper":
+		image, err = client.WallpaperPNG(request.Context())
+
+	case path == "orientation":
+		orientation, err := client.InterfaceOrientation(request.Context())
+		if err != nil {
+			writeError(writer, http.StatusBadGateway, err)
+			return
+		}
+		name, ok := springBoardOrientations[orientation]
+		if !ok {
+			name = springBoardOrientations[0]
+		}
+		writeJSON(writer, http.StatusOK, map[string]interface{}{"orientation": name, "value": orientation})
+		return
+
+	default:
+		writeError(writer, http.StatusNotFound, fmt.Errorf("unknown springboard request %s", path))
+		return
+	}
+
+	if err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "image/png")
+	writer.WriteHeader(http.StatusOK)
+	writer.Write(image)
+}
+
+// decodeIconState reads an icon state from a plist or JSON request body.
+func decodeIconState(writer http.ResponseWriter, request *http.Request) (interface{}, error) {
+	body := http.MaxBytesReader(writer, request.Body, springBoardMaxIconStateSize)
+
+	var state interface{}
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if mediaType == springBoardPlistMediaType {
+		data, err := ioutil.ReadAll(body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = plist.Unmarshal(data, &state); err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+
+	decoder := json.NewDecoder(body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&state); err != nil {
+		return nil, err
+	}
+	return plistNumbers(state), nil
+}
+
+// plistNumbers converts the json.Numbers of a decoded JSON value into integers
+// or reals so they are encoded as plist numbers.
+func plistNumbers(value interface{}) interface{} {
+	switch value := value.(type) {
+	case json.Number:
+		if integer, err := value.Int64(); err == nil {
+			return integer
+		}
+		real, _ := value.Float64()
+		return real
+	case []interface{}:
+		for index, element := range value {
+			value[index] = plistNumbers(element)
+		}
+	case map[string]interface{}:
+		for key, element := range value {
+			value[key] = plistNumbers(element)
+		}
+	}
+	return value
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"howett.net/plist"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDecodeIconStateKeepsPlistTypes(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	state := []interface{}{
+		[]interface{}{
+			map[string]interface{}{
+				"displayIdentifier": "com.apple.mobilesafari",
+				"iconModDate":       modified,
+				"badgeData":         []byte{1, 2, 3},
+				"scale":             1.0,
+				"listType":          uint64(2),
+				"hidden":            false,
+			},
+		},
+	}
+	data, err := plist.Marshal(state, plist.XMLFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := httptest.NewRequest("PUT", "/v1/devices/test/springboard/icons", bytes.NewReader(data))
+	request.Header.Set("Content-Type", "application/x-plist; charset=utf-8")
+	decoded, err := decodeIconState(httptest.NewRecorder(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icon := decoded.([]interface{})[0].([]interface{})[0].(map[string]interface{})
+	if date, ok := icon["iconModDate"].(time.Time); !ok || !date.Equal(modified) {
+		t.Errorf("expected the date back, got %T %v", icon["iconModDate"], icon["iconModDate"])
+	}
+	if badge, ok := icon["badgeData"].([]byte); !ok || !bytes.Equal(badge, []byte{1, 2, 3}) {
+		t.Errorf("expected the data back, got %T", icon["badgeData"])
+	}
+	if scale, ok := icon["scale"].(float64); !ok || scale != 1.0 {
+		t.Errorf("expected a real, got %T", icon["scale"])
+	}
+	if listType, ok := icon["listType"].(uint64); !ok || listType != 2 {
+		t.Errorf("expected an integer, got %T", icon["listType"])
+	}
+	if hidden, ok := icon["hidden"].(bool); !ok || hidden {
+		t.Errorf("expected a boolean, got %T", icon["hidden"])
+	}
+}
+
+func TestDecodeIconStateJSONNumbers(t *testing.T) {
+	request := httptest.NewRequest("PUT", "/v1/devices/test/springboard/icons", strings.NewReader(`[[{"listType": 2, "scale": 1.5}]]`))
+	request.Header.Set("Content-Type", "application/json")
+	decoded, err := decodeIconState(httptest.NewRecorder(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	icon := decoded.([]interface{})[0].([]interface{})[0].(map[string]interface{})
+	if _, ok := icon["listType"].(int64); !ok {
+		t.Errorf("expected an integer, got %T", icon["listType"])
+	}
+	if _, ok := icon["scale"].(float64); !ok {
+		t.Errorf("expected a real, got %T", icon["scale"])
+	}
+}
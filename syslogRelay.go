@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const SyslogRelayServiceName = "com.apple.syslog_relay"
+
+// Lines buffered per subscriber before lines are dropped for it
+const syslogSubscriberBuffer = 256
+
+// SyslogRelay shares one syslog_relay stream of a device between subscribers.
+// The stream is started with the first subscriber and closed after the last.
+type SyslogRelay struct {
+	device *RemoteDevice
+
+	mutex       sync.Mutex
+	subscribers map[*SyslogSubscriber]bool
+	conn        net.Conn
+}
+
+// SyslogSubscriber receives the lines matching its filters.
+type SyslogSubscriber struct {
+	lines chan string
+
+	// Only lines logged by this process, if set
+	process string
+
+	// Only lines matching this expression, if set
+	pattern *regexp.Regexp
+
+	dropped int
+}
+
+func newSyslogRelay(device *RemoteDevice) *SyslogRelay {
+	return &SyslogRelay{
+		device:      device,
+		subscribers: make(map[*SyslogSubscriber]bool),
+	}
+}
+
+// subscribe adds subscriber, starting the device stream if it isn't running.
+func (relay *SyslogRelay) subscribe(ctx context.Context, subscriber *SyslogSubscriber) error {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+
+	if relay.conn == nil {
+		conn, _, err := relay.device.OpenServiceStream(ctx, SyslogRelayServiceName)
+		if err != nil {
+			return err
+		}
+		relay.conn = conn
+		go relay.run(conn)
+	}
+
+	relay.subscribers[subscriber] = true
+	return nil
+}
+
+// unsubscribe removes subscriber and stops the stream when nobody is left.
+func (relay *SyslogRelay) unsubscribe(subscriber *SyslogSubscriber) {
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+
+	if !relay.subscribers[subscriber] {
+		return
+	}
+	delete(relay.subscribers, subscriber)
+	close(subscriber.lines)
+
+	if len(relay.subscribers) == 0 && relay.conn != nil {
+		relay.conn.Close()
+		relay.conn = nil
+	}
+}
+
+// run splits the NUL delimited stream into lines and fans them out.
+func (relay *SyslogRelay) run(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString(0)
+		if len(line) > 0 {
+			relay.dispatch(strings.TrimRight(line, "\x00\n"))
+		}
+		if err != nil {
+			fmt.Printf("SyslogRelay %s stream ended: %s\n", relay.device.serialNumber, err)
+			break
+		}
+	}
+
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+
+	// Unless a new stream already replaced this one, end every subscription
+	if relay.conn == conn {
+		for subscriber := range relay.subscribers {
+			close(subscriber.lines)
+		}
+		relay.subscribers = make(map[*SyslogSubscriber]bool)
+		relay.conn.Close()
+		relay.conn = nil
+	}
+}
+
+func (relay *SyslogRelay) dispatch(line string) {
+	if line == "" {
+		return
+	}
+
+	relay.mutex.Lock()
+	defer relay.mutex.Unlock()
+
+	for subscriber := range relay.subscribers {
+		if !subscriber.matches(line) {
+			continue
+		}
+
+		select {
+		case subscriber.lines <- line:
+		default:
+			subscriber.dropped++
+		}
+	}
+}
+
+// Date, time and device name in front of the process of a syslog line. Days
+// below 10 are padded with a space.
+var syslogLinePattern = regexp.MustCompile(`^[A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}:\d{2} \S+ ([^\s\[(:]+)`)
+
+// syslogProcess extracts the process name from a line formatted like
+// "Oct 19 10:00:00 iPhone SpringBoard(FrontBoard)[57] <Notice>: message".
+func syslogProcess(line string) string {
+	match := syslogLinePattern.FindStringSubmatch(line)
+	if match == nil {
+		return ""
+	}
+	return match[1]
+}
+
+func (subscriber *SyslogSubscriber) matches(line string) bool {
+	if subscriber.process != "" && syslogProcess(line) != subscriber.process {
+		return false
+	}
+	if subscriber.pattern != nil && !subscriber.pattern.MatchString(line) {
+		return false
+	}
+	return true
+}
+
+// handleSyslog serves /v1/devices/{id}/syslog?process=name&match=regex either as
+// a chunked text response or, when upgraded, as a websocket with one message per line.
+func handleSyslog(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, path string) {
+	subscriber := &SyslogSubscriber{
+		lines:   make(chan string, syslogSubscriberBuffer),
+		process: request.URL.Query().Get("process"),
+	}
+
+	if expression := request.URL.Query().Get("match"); expression != "" {
+		pattern, err := regexp.Compile(expression)
+		if err != nil {
+			writeError(writer, http.StatusBadRequest, err)
+			return
+		}
+		subscriber.pattern = pattern
+	}
+
+	if err := device.syslogRelay.subscribe(request.Context(), subscriber); err != nil {
+		writeError(writer, http.StatusBadGateway, err)
+		return
+	}
+	defer device.syslogRelay.unsubscribe(subscriber)
+
+	if websocket.IsWebSocketUpgrade(request) {
+		streamSyslogWebsocket(device, writer, request, subscriber)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	writer.Header().Set("X-Content-Type-Options", "nosniff")
+	writer.WriteHeader(http.StatusOK)
+	flusher, _ := writer.(http.Flusher)
+
+	for {
+		select {
+		case line, ok := <-subscriber.lines:
+			if !ok {
+				return
+			}
+			if _, err := fmt.Fprintln(writer, line); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-request.Context().Done():
+			return
+		}
+	}
+}
+
+func streamSyslogWebsocket(device *RemoteDevice, writer http.ResponseWriter, request *http.Request, subscriber *SyslogSubscriber) {
+	connection, err := device.hub.upgrader.Upgrade(writer, request, nil)
+	if err != nil {
+		fmt.Printf("SyslogRelay websocket upgrade error %s\n", err)
+		return
+	}
+	defer connection.Close()
+
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := connection.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		select {
+		case line, ok := <-subscriber.lines:
+			if !ok {
+				return
+			}
+			connection.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := connection.WriteMessage(websocket.TextMessage, []byte(line)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestSyslogProcess(t *testing.T) {
+	tests := []struct {
+		line    string
+		process string
+	}{
+		{"Oct 19 10:00:00 iPhone SpringBoard(FrontBoard)[57] <Notice>: message", "SpringBoard"},
+		{"Oct  9 10:00:00 iPhone SpringBoard(FrontBoard)[57] <Notice>: message", "SpringBoard"},
+		{"Oct  9 08:01:02 iPhone kernel[0] <Notice>: AppleKeyStore: operation failed", "kernel"},
+		{"Jan 1 00:00:00 Someones-iPad backboardd[64] <Error>: [bar] baz", "backboardd"},
+		{"Feb 28 23:59:59 iPhone com.apple.WebKit.WebContent(WebCore)[812] <Notice>: load", "com.apple.WebKit.WebContent"},
+		{"Mar 03 12:00:00 iPhone locationd: no pid", "locationd"},
+		{"Oct  9 10:00:00 iPhone", ""},
+		{"    continuation of a multi line message", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		if process := syslogProcess(test.line); process != test.process {
+			t.Errorf("%q: expected %q, got %q", test.line, test.process, process)
+		}
+	}
+}
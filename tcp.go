@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"fmt"
 	"gopkg.in/restruct.v1"
+	"sync"
+	"time"
 )
 
 const (
@@ -22,10 +24,64 @@ const (
 	TCPStateClosing    = 3
 	TCPStateClosed     = 4
 	TCPStateRefused    = 5
+
+	// We sent FIN and wait for the device to acknowledge it
+	TCPStateFinWait1 = 6
+
+	// Our FIN was acknowledged, the device may still send data
+	TCPStateFinWait2 = 7
+
+	// Both sides closed, lingering for late retransmissions before the port is freed
+	TCPStateTimeWait = 8
+
+	// The device sent FIN, we may still send until our side is closed as well.
+	// TCPStateClosing follows until our FIN is acknowledged.
+	TCPStateCloseWait = 9
+)
+
+// How long a channel lingers in TCPStateTimeWait
+const TCPTimeWaitDuration = 2 * time.Second
+
+// Unanswered keepalive probes before a channel is considered dead
+const TCPKeepAliveProbes = 3
+
+// TCPChannelConfig holds the timers a device applies to its channels. A zero
+// duration disables the corresponding timer.
+type TCPChannelConfig struct {
+	clock Clock
+
+	// How long to wait for SYN|ACK before reporting TCPStateRefused
+	connectTimeout time.Duration
+
+	// Idle time after which an established channel is probed
+	keepAliveInterval time.Duration
+
+	// Time without data in either direction after which a channel is aborted
+	idleTimeout time.Duration
+}
+
+func defaultChannelConfig() TCPChannelConfig {
+	return TCPChannelConfig{
+		clock:             systemClock{},
+		connectTimeout:    *connectTimeoutFlag,
+		keepAliveInterval: *keepAliveFlag,
+		idleTimeout:       *idleTimeoutFlag,
+	}
+}
+
+const (
+	// Receive buffer we advertise to the device, the same size usbmuxd uses
+	TCPReceiveWindow = 131072
+
+	// The 16 bit window field is scaled by this shift in both directions
+	TCPWindowShift = 8
 )
 
 type TCPChannelSender interface {
 	sendTCPData(data []byte)
+	maxSegmentSize() int
+	channelClosed(channel *TCPChannel)
+	channelConfig() *TCPChannelConfig
 }
 
 type TCPChannelHandler interface {
@@ -33,6 +89,29 @@ type TCPChannelHandler interface {
 	connectionStateChange(state int)
 }
 
+// TCPChannelBufferingHandler is implemented by handlers that keep received data
+// queued after receiveData returns. They must hand drained byte counts back via
+// TCPChannel.consume, otherwise the advertised receive window never reopens.
+type TCPChannelBufferingHandler interface {
+	TCPChannelHandler
+	buffersReceivedData()
+}
+
+// TCPChannelWriteHandler is implemented by handlers that want to know when
+// queued data went out, to apply back pressure to their own writers.
+type TCPChannelWriteHandler interface {
+	TCPChannelHandler
+	sendSpaceAvailable()
+}
+
+// TCPChannelEvent is a state change, received data or send progress waiting to
+// be handed to the channel's handler.
+type TCPChannelEvent struct {
+	state    int
+	data     []byte
+	writable bool
+}
+
 type TCPHeader struct {
 	SourcePort      uint16
 	DestinationPort uint16
@@ -48,6 +127,7 @@ const TCPHeaderSize = 20
 const TCPOffset = 0x05 << 12
 
 type TCPChannel struct {
+	mutex             sync.Mutex
 	handler           TCPChannelHandler
 	sender            TCPChannelSender
 	sourcePort        uint16
@@ -60,6 +140,47 @@ type TCPChannel struct {
 	txBytes           uint32
 	window            uint32
 	state             int
+
+	// Receive window last advertised by the device (already scaled)
+	peerWindow uint32
+
+	// Window we last advertised to the device (already scaled)
+	advertisedWindow uint32
+
+	// Bytes handed to a buffering handler that it has not drained yet
+	unconsumed uint32
+
+	// Data accepted by send that does not fit into the peer window yet
+	pending []byte
+
+	// A FIN goes out once pending is drained
+	finQueued bool
+	finSent   bool
+
+	// The device sent its FIN
+	peerFinished bool
+
+	// Short segments go out immediately instead of waiting for outstanding
+	// data to be acknowledged
+	noDelay bool
+
+	// Handler callbacks collected under mutex, see unlockAndDispatch
+	events []TCPChannelEvent
+
+	config *TCPChannelConfig
+
+	connectTimer   ClockTimer
+	keepAliveTimer ClockTimer
+	idleTimer      ClockTimer
+
+	// Last time anything arrived from the device
+	lastReceived time.Time
+
+	// Last time data moved in either direction
+	lastActivity time.Time
+
+	// Keepalive probes sent since the device last answered
+	unansweredProbes int
 }
 
 func createChannel(sourcePort uint16, destinationPort uint16, sender TCPChannelSender, handler TCPChannelHandler) *TCPChannel {
@@ -69,7 +190,7 @@ func createChannel(sourcePort uint16, destinationPort uint16, sender TCPChannelS
 		sender:            sender,
 		sourcePort:        sourcePort,
 		destinationPort:   destinationPort,
-		window:            131072,
+		window:            TCPReceiveWindow,
 		handler:           handler,
 		txSequence:        0,
 		rxSequence:        0,
@@ -77,23 +198,168 @@ func createChannel(sourcePort uint16, destinationPort uint16, sender TCPChannelS
 		rxAcknowledgement: 0,
 		rxBytes:           0,
 		txBytes:           0,
+		config:            sender.channelConfig(),
 	}
 
+	channel.mutex.Lock()
 	channel.sendTCP(TCPHeaderFlagSYN, []byte{})
 	channel.state = TCPStateConnecting
+	if channel.config.connectTimeout > 0 {
+		channel.connectTimer = channel.config.clock.AfterFunc(channel.config.connectTimeout, channel.connectTimedOut)
+	}
+	channel.mutex.Unlock()
 
 	return channel
 }
 
+// send queues data for the device and transmits as much of it as the device's
+// receive window currently allows. The rest goes out as acknowledgements arrive.
+//
+// Small writes are coalesced: while data is unacknowledged, a segment is only
+// sent once a full one has accumulated (Nagle's algorithm), unless the channel
+// has no-delay set.
 func (channel *TCPChannel) send(data []byte) {
-	channel.sendTCP(TCPHeaderFlagACK, data)
+	channel.mutex.Lock()
+	defer channel.unlockAndDispatch()
+
+	if !channel.sending() {
+		fmt.Printf("TCPChannel %d dropping %d bytes sent in state %d\n", channel.sourcePort, len(data), channel.state)
+		return
+	}
+
+	channel.pending = append(channel.pending, data...)
+	channel.lastActivity = channel.config.clock.Now()
+	channel.flush()
+}
+
+// consume is called by buffering handlers once count received bytes have been
+// drained by the local consumer.
+func (channel *TCPChannel) consume(count int) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	if uint32(count) >= channel.unconsumed {
+		channel.unconsumed = 0
+	} else {
+		channel.unconsumed -= uint32(count)
+	}
+
+	// Only announce the reopened window once it grew by a meaningful amount, so a
+	// slow reader does not cause a stream of tiny window updates. The window can
+	// also have shrunk since it was advertised, so the growth is signed.
+	growth := int64(channel.receiveWindow()) - int64(channel.advertisedWindow)
+	if channel.receiving() && growth >= int64(channel.window/2) {
+		channel.sendTCP(TCPHeaderFlagACK, []byte{})
+	}
+}
+
+// setNoDelay turns off coalescing of small writes, like TCP_NODELAY. Request
+// and reply protocols want it, since Nagle holds a short request back until the
+// previous one was acknowledged.
+func (channel *TCPChannel) setNoDelay(noDelay bool) {
+	channel.mutex.Lock()
+	defer channel.unlockAndDispatch()
+
+	channel.noDelay = noDelay
+	if noDelay {
+		channel.flush()
+	}
+}
+
+// currentState returns the channel's state for callers not holding mutex.
+func (channel *TCPChannel) currentState() int {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	return channel.state
+}
+
+// queued returns the number of bytes accepted by send but not transmitted yet.
+func (channel *TCPChannel) queued() int {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	return len(channel.pending)
+}
+
+// receiveWindow is the free space left in our receive buffer.
+func (channel *TCPChannel) receiveWindow() uint32 {
+	if channel.unconsumed >= channel.window {
+		return 0
+	}
+	return channel.window - channel.unconsumed
+}
+
+// inFlight is the number of bytes sent but not acknowledged by the device yet.
+func (channel *TCPChannel) inFlight() uint32 {
+	return channel.txSequence - channel.rxAcknowledgement
+}
+
+// sendable is the number of bytes the device is currently willing to accept.
+func (channel *TCPChannel) sendable() uint32 {
+	inFlight := channel.inFlight()
+	if channel.peerWindow <= inFlight {
+		return 0
+	}
+	return channel.peerWindow - inFlight
+}
+
+// flush transmits pending data in segments within the peer window, followed by
+// a queued FIN. The caller holds mutex.
+func (channel *TCPChannel) flush() {
+	if channel.state != TCPStateConnected && channel.state != TCPStateCloseWait && !channel.finQueued {
+		return
+	}
+
+	segmentSize := uint32(channel.sender.maxSegmentSize())
+
+	queued := len(channel.pending)
+	defer func() {
+		if _, notify := channel.handler.(TCPChannelWriteHandler); notify && len(channel.pending) < queued {
+			channel.events = append(channel.events, TCPChannelEvent{writable: true})
+		}
+	}()
+
+	for len(channel.pending) > 0 {
+		size := channel.sendable()
+		if size == 0 {
+			fmt.Printf("TCPChannel %d peer window full, %d bytes queued\n", channel.sourcePort, len(channel.pending))
+			return
+		}
+		if size > segmentSize {
+			size = segmentSize
+		}
+		if size > uint32(len(channel.pending)) {
+			size = uint32(len(channel.pending))
+		}
+
+		// Hold back a short segment until the outstanding data is acknowledged,
+		// unless we are closing and nothing more will be added
+		if size < segmentSize && size == uint32(len(channel.pending)) && channel.inFlight() > 0 && !channel.finQueued && !channel.noDelay {
+			return
+		}
+
+		channel.sendTCP(TCPHeaderFlagACK, channel.pending[:size])
+		channel.pending = channel.pending[size:]
+	}
+	channel.pending = nil
+
+	if channel.finQueued {
+		channel.sendTCP(TCPHeaderFlagFIN|TCPHeaderFlagACK, []byte{})
+		// The FIN occupies one sequence number
+		channel.txSequence++
+		channel.finQueued = false
+		channel.finSent = true
+	}
 }
 
 func (channel *TCPChannel) sendTCP(flags uint16, data []byte) {
+	channel.advertisedWindow = channel.receiveWindow()
+
 	header := &TCPHeader{
 		SourcePort:      channel.sourcePort,
 		DestinationPort: channel.destinationPort,
-		Window:          uint16(channel.window >> 8),
+		Window:          uint16(channel.advertisedWindow >> TCPWindowShift),
 		Sequence:        channel.txSequence,
 		Acknowledgement: channel.txAcknowledgement,
 		OffsetFlags:     flags | TCPOffset,
@@ -102,10 +368,7 @@ func (channel *TCPChannel) sendTCP(flags uint16, data []byte) {
 	channel.txBytes += uint32(len(data))
 	channel.txSequence += uint32(len(data))
 
-	fmt.Printf("TCPChannel sending packet flags %x, seq %d, ack %d, length %d\n", flags, header.Sequence, header.Acknowledgement, len(data))
-	if channel.state != TCPStateConnecting {
-
-	}
+	fmt.Printf("TCPChannel sending packet flags %x, seq %d, ack %d, window %d, length %d\n", flags, header.Sequence, header.Acknowledgement, channel.advertisedWindow, len(data))
 
 	headerData, err := restruct.Pack(binary.BigEndian, header)
 	if err != nil {
@@ -125,51 +388,320 @@ func (header *TCPHeader) hasFlag(flag uint16) bool {
 }
 
 func (channel *TCPChannel) receivePacket(header *TCPHeader, data []byte) {
-	fmt.Printf("TCPChannel received packet flags %x, seq %d, ack %d, length %d\n", header.OffsetFlags, header.Sequence, header.Acknowledgement, len(data))
+	channel.mutex.Lock()
+	channel.processPacket(header, data)
+	channel.unlockAndDispatch()
+}
 
-	channel.rxSequence = header.Sequence
-	channel.rxAcknowledgement = header.Acknowledgement
+// Close shuts down our sending side once all queued data has gone out. Data
+// from the device is still delivered until it closes its side as well.
+func (channel *TCPChannel) Close() {
+	channel.mutex.Lock()
+	switch channel.state {
+	case TCPStateNew, TCPStateConnecting:
+		// Nothing to drain yet, so don't leave the device half open
+		channel.reset()
+	case TCPStateConnected:
+		channel.finQueued = true
+		channel.setState(TCPStateFinWait1)
+		channel.flush()
+	case TCPStateCloseWait:
+		channel.finQueued = true
+		channel.setState(TCPStateClosing)
+		channel.flush()
+	}
+	channel.unlockAndDispatch()
+}
 
-	if header.hasFlag(TCPHeaderFlagRST) {
-		channel.state = TCPStateRefused
-		channel.handler.connectionStateChange(channel.state)
+// Abort resets the connection immediately, discarding any queued data.
+func (channel *TCPChannel) Abort() {
+	channel.mutex.Lock()
+	if !channel.finished() {
+		channel.reset()
+	}
+	channel.unlockAndDispatch()
+}
+
+// setHandler hands the channel over to a new handler, used when a service
+// switches its stream to TLS.
+func (channel *TCPChannel) setHandler(handler TCPChannelHandler) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+
+	channel.handler = handler
+}
+
+// reset sends RST and closes the channel. The caller holds mutex.
+func (channel *TCPChannel) reset() {
+	channel.sendTCP(TCPHeaderFlagRST|TCPHeaderFlagACK, []byte{})
+	channel.pending = nil
+	channel.finQueued = false
+	channel.setState(TCPStateClosed)
+}
+
+// finished reports whether the channel reached a terminal state.
+func (channel *TCPChannel) finished() bool {
+	return channel.state == TCPStateClosed || channel.state == TCPStateRefused
+}
+
+// sending reports whether send still accepts data. Data sent before the
+// handshake completed goes out once it did.
+func (channel *TCPChannel) sending() bool {
+	switch channel.state {
+	case TCPStateNew, TCPStateConnecting, TCPStateConnected, TCPStateCloseWait:
+		return true
+	}
+	return false
+}
+
+// receiving reports whether the device may still send us data.
+func (channel *TCPChannel) receiving() bool {
+	switch channel.state {
+	case TCPStateConnected, TCPStateFinWait1, TCPStateFinWait2:
+		return !channel.peerFinished
+	}
+	return false
+}
+
+// finAcknowledged reports whether the device acknowledged the FIN we sent.
+func (channel *TCPChannel) finAcknowledged() bool {
+	return channel.finSent && channel.rxAcknowledgement == channel.txSequence
+}
+
+// setState moves the channel to state and queues the change for the handler.
+// Terminal states are never left, so each of them is reported only once. The
+// caller holds mutex.
+func (channel *TCPChannel) setState(state int) {
+	if channel.finished() || channel.state == state {
 		return
 	}
 
-	if header.hasFlag(TCPHeaderFlagFIN) && channel.state != TCPStateClosing {
-		channel.state = TCPStateClosing
-		channel.handler.connectionStateChange(channel.state)
-		channel.sendTCP(TCPHeaderFlagFIN|TCPHeaderFlagACK, []byte{})
+	fmt.Printf("TCPChannel %d state %d -> %d\n", channel.sourcePort, channel.state, state)
+	channel.state = state
+	channel.events = append(channel.events, TCPChannelEvent{state: state})
+
+	switch state {
+	case TCPStateConnected:
+		channel.stopTimer(&channel.connectTimer)
+		now := channel.config.clock.Now()
+		channel.lastReceived = now
+		channel.lastActivity = now
+		if channel.config.keepAliveInterval > 0 {
+			channel.keepAliveTimer = channel.config.clock.AfterFunc(channel.config.keepAliveInterval, channel.keepAliveDue)
+		}
+		if channel.config.idleTimeout > 0 {
+			channel.idleTimer = channel.config.clock.AfterFunc(channel.config.idleTimeout, channel.idleDue)
+		}
+	case TCPStateTimeWait:
+		channel.config.clock.AfterFunc(TCPTimeWaitDuration, channel.timeWaitExpired)
+	case TCPStateClosed, TCPStateRefused:
+		channel.stopTimer(&channel.connectTimer)
+		channel.stopTimer(&channel.keepAliveTimer)
+		channel.stopTimer(&channel.idleTimer)
+	}
+}
+
+func (channel *TCPChannel) stopTimer(timer *ClockTimer) {
+	if *timer != nil {
+		(*timer).Stop()
+		*timer = nil
+	}
+}
+
+// connectTimedOut gives up on a SYN the device never answered.
+func (channel *TCPChannel) connectTimedOut() {
+	channel.mutex.Lock()
+	if channel.state == TCPStateConnecting {
+		fmt.Printf("TCPChannel %d connect to %d timed out\n", channel.sourcePort, channel.destinationPort)
+		channel.sendTCP(TCPHeaderFlagRST|TCPHeaderFlagACK, []byte{})
+		channel.pending = nil
+		channel.setState(TCPStateRefused)
+	}
+	channel.unlockAndDispatch()
+}
+
+// keepAliveDue probes the device when nothing arrived for keepAliveInterval,
+// aborting the channel after TCPKeepAliveProbes probes went unanswered.
+func (channel *TCPChannel) keepAliveDue() {
+	channel.mutex.Lock()
+	defer channel.unlockAndDispatch()
+
+	if channel.finished() || channel.keepAliveTimer == nil {
 		return
 	}
 
-	if channel.state == TCPStateClosing && header.hasFlag(TCPHeaderFlagACK) {
-		channel.state = TCPStateClosed
-		channel.handler.connectionStateChange(channel.state)
+	interval := channel.config.keepAliveInterval
+	idle := channel.config.clock.Now().Sub(channel.lastReceived)
+	if idle < interval && channel.unansweredProbes == 0 {
+		channel.keepAliveTimer = channel.config.clock.AfterFunc(interval-idle, channel.keepAliveDue)
 		return
 	}
 
-	if channel.state == TCPStateConnecting &&
-		header.hasFlag(TCPHeaderFlagSYN) &&
-		header.hasFlag(TCPHeaderFlagACK) {
+	if channel.unansweredProbes >= TCPKeepAliveProbes {
+		fmt.Printf("TCPChannel %d keepalive got no answer, aborting\n", channel.sourcePort)
+		channel.reset()
+		return
+	}
 
-		channel.txSequence++
-		channel.txAcknowledgement++
-		channel.rxBytes = channel.rxSequence
-		channel.state = TCPStateConnected
-		channel.handler.connectionStateChange(channel.state)
+	// A segment one byte behind what the device has seen makes it acknowledge
+	channel.txSequence--
+	channel.sendTCP(TCPHeaderFlagACK, []byte{})
+	channel.txSequence++
+	channel.unansweredProbes++
+
+	channel.keepAliveTimer = channel.config.clock.AfterFunc(interval, channel.keepAliveDue)
+}
+
+// idleDue aborts a channel that moved no data for idleTimeout.
+func (channel *TCPChannel) idleDue() {
+	channel.mutex.Lock()
+	defer channel.unlockAndDispatch()
+
+	if channel.finished() || channel.idleTimer == nil {
 		return
 	}
 
-	if channel.state == TCPStateConnected {
-		if len(data) > 0 {
-			channel.rxBytes += uint32(len(data))
-			channel.txAcknowledgement += uint32(len(data))
+	timeout := channel.config.idleTimeout
+	idle := channel.config.clock.Now().Sub(channel.lastActivity)
+	if idle < timeout {
+		channel.idleTimer = channel.config.clock.AfterFunc(timeout-idle, channel.idleDue)
+		return
+	}
+
+	fmt.Printf("TCPChannel %d idle for %s, reaping\n", channel.sourcePort, idle)
+	channel.reset()
+}
+
+func (channel *TCPChannel) timeWaitExpired() {
+	channel.mutex.Lock()
+	if channel.state == TCPStateTimeWait {
+		channel.setState(TCPStateClosed)
+	}
+	channel.unlockAndDispatch()
+}
+
+// unlockAndDispatch releases mutex and then delivers the queued events. The
+// handler is called without holding the lock so it can send in response.
+func (channel *TCPChannel) unlockAndDispatch() {
+	events := channel.events
+	handler := channel.handler
+	channel.events = nil
+	channel.mutex.Unlock()
+
+	for _, event := range events {
+		if event.data != nil {
+			handler.receiveData(event.data)
+			continue
+		}
+		if event.writable {
+			if writeHandler, ok := handler.(TCPChannelWriteHandler); ok {
+				writeHandler.sendSpaceAvailable()
+			}
+			continue
+		}
+
+		if event.state == TCPStateClosed || event.state == TCPStateRefused {
+			channel.sender.channelClosed(channel)
+		}
+		handler.connectionStateChange(event.state)
+	}
+}
+
+// processPacket applies an incoming packet to the channel state, queueing
+// events for the handler. The caller holds mutex.
+func (channel *TCPChannel) processPacket(header *TCPHeader, data []byte) {
+	fmt.Printf("TCPChannel received packet flags %x, seq %d, ack %d, window %d, length %d\n", header.OffsetFlags, header.Sequence, header.Acknowledgement, uint32(header.Window)<<TCPWindowShift, len(data))
+
+	channel.rxSequence = header.Sequence
+	channel.peerWindow = uint32(header.Window) << TCPWindowShift
+	channel.lastReceived = channel.config.clock.Now()
+	channel.unansweredProbes = 0
+
+	// Ignore acknowledgements older than one we have already seen
+	if int32(header.Acknowledgement-channel.rxAcknowledgement) > 0 {
+		channel.rxAcknowledgement = header.Acknowledgement
+	}
+
+	if channel.finished() {
+		return
+	}
 
+	if header.hasFlag(TCPHeaderFlagRST) {
+		channel.pending = nil
+		channel.finQueued = false
+		channel.setState(TCPStateRefused)
+		return
+	}
+
+	if channel.state == TCPStateConnecting {
+		if header.hasFlag(TCPHeaderFlagSYN) && header.hasFlag(TCPHeaderFlagACK) {
+			channel.txSequence++
+			channel.txAcknowledgement++
+			channel.rxAcknowledgement = channel.txSequence
+			channel.rxBytes = channel.rxSequence
+			channel.setState(TCPStateConnected)
+
+			// Complete the handshake so the device learns our receive window, then
+			// send anything that was queued while connecting
 			channel.sendTCP(TCPHeaderFlagACK, []byte{})
+			channel.flush()
+		}
+		return
+	}
+
+	acknowledge := false
 
-			channel.handler.receiveData(data)
+	if len(data) > 0 && channel.receiving() {
+		channel.lastActivity = channel.lastReceived
+		channel.rxBytes += uint32(len(data))
+		channel.txAcknowledgement += uint32(len(data))
+		if _, buffering := channel.handler.(TCPChannelBufferingHandler); buffering {
+			channel.unconsumed += uint32(len(data))
 		}
+
+		channel.events = append(channel.events, TCPChannelEvent{data: data})
+		acknowledge = true
 	}
 
+	if channel.state == TCPStateFinWait1 && channel.finAcknowledged() {
+		channel.setState(TCPStateFinWait2)
+	}
+
+	if header.hasFlag(TCPHeaderFlagFIN) {
+		if channel.receiving() {
+			// The FIN occupies one sequence number
+			channel.txAcknowledgement++
+			channel.peerFinished = true
+		}
+		acknowledge = true
+
+		switch channel.state {
+		case TCPStateConnected:
+			// The device is done sending, our side stays open until Close
+			channel.setState(TCPStateCloseWait)
+		case TCPStateFinWait2:
+			channel.setState(TCPStateTimeWait)
+		}
+	}
+
+	switch channel.state {
+	case TCPStateFinWait1:
+		// Both FINs crossed, wait for ours to be acknowledged
+		if channel.peerFinished && channel.finAcknowledged() {
+			channel.setState(TCPStateTimeWait)
+		}
+	case TCPStateClosing:
+		if channel.finAcknowledged() {
+			channel.setState(TCPStateClosed)
+			return
+		}
+	}
+
+	// The acknowledgement or window in this packet may have made room, and the
+	// ACK for received data rides along with the first segment if there is one
+	sent := channel.txSequence
+	channel.flush()
+	if acknowledge && sent == channel.txSequence {
+		channel.sendTCP(TCPHeaderFlagACK, []byte{})
+	}
 }
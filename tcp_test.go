@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gopkg.in/restruct.v1"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testPacket struct {
+	header TCPHeader
+	data   []byte
+}
+
+// testSender records the packets channels send instead of passing them to a device.
+type testSender struct {
+	mutex   sync.Mutex
+	packets []*testPacket
+	closed  []*TCPChannel
+	clock   *manualClock
+	config  TCPChannelConfig
+	segment int
+}
+
+func newTestSender() *testSender {
+	clock := newManualClock()
+	return &testSender{
+		clock:   clock,
+		config:  TCPChannelConfig{clock: clock},
+		segment: 1000,
+	}
+}
+
+// decodeTestPacket splits a TCP packet as sent by a channel.
+func decodeTestPacket(data []byte) *testPacket {
+	packet := &testPacket{data: append([]byte{}, data[TCPHeaderSize:]...)}
+	if err := restruct.Unpack(data[:TCPHeaderSize], binary.BigEndian, &packet.header); err != nil {
+		panic(err)
+	}
+	return packet
+}
+
+func (sender *testSender) sendTCPData(data []byte) {
+	packet := decodeTestPacket(data)
+
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	sender.packets = append(sender.packets, packet)
+}
+
+func (sender *testSender) maxSegmentSize() int {
+	return sender.segment
+}
+
+func (sender *testSender) channelClosed(channel *TCPChannel) {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+	sender.closed = append(sender.closed, channel)
+}
+
+func (sender *testSender) channelConfig() *TCPChannelConfig {
+	return &sender.config
+}
+
+// take returns the packets sent since the last call.
+func (sender *testSender) take() []*testPacket {
+	sender.mutex.Lock()
+	defer sender.mutex.Unlock()
+
+	packets := sender.packets
+	sender.packets = nil
+	return packets
+}
+
+// testHandler records what a channel hands to its handler.
+type testHandler struct {
+	mutex  sync.Mutex
+	data   []byte
+	states []int
+}
+
+func (handler *testHandler) receiveData(data []byte) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.data = append(handler.data, data...)
+}
+
+func (handler *testHandler) connectionStateChange(state int) {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+	handler.states = append(handler.states, state)
+}
+
+func (handler *testHandler) lastState() int {
+	handler.mutex.Lock()
+	defer handler.mutex.Unlock()
+
+	if len(handler.states) == 0 {
+		return -1
+	}
+	return handler.states[len(handler.states)-1]
+}
+
+// testPeer plays the device end of a channel.
+type testPeer struct {
+	channel  *TCPChannel
+	sequence uint32
+	window   uint16
+}
+
+// connect opens a channel on sender and completes the handshake for it.
+func connect(t *testing.T, sender *testSender, handler TCPChannelHandler) *testPeer {
+	channel := createChannel(MUXSourcePortFirst, 62078, sender, handler)
+	syn := sender.take()
+	if len(syn) != 1 || !syn[0].header.hasFlag(TCPHeaderFlagSYN) {
+		t.Fatalf("expected a single SYN, got %d packets", len(syn))
+	}
+
+	peer := &testPeer{channel: channel, sequence: 1000, window: 0xFFFF}
+	peer.send(TCPHeaderFlagSYN|TCPHeaderFlagACK, nil)
+	peer.sequence++
+
+	if state := channel.currentState(); state != TCPStateConnected {
+		t.Fatalf("channel in state %d after SYN|ACK", state)
+	}
+	sender.take()
+
+	return peer
+}
+
+// send delivers a packet from the device acknowledging everything sent so far.
+func (peer *testPeer) send(flags uint16, data []byte) {
+	peer.sendAck(flags, peer.channel.txSequence, data)
+}
+
+func (peer *testPeer) sendAck(flags uint16, ack uint32, data []byte) {
+	header := &TCPHeader{
+		SourcePort:      peer.channel.destinationPort,
+		DestinationPort: peer.channel.sourcePort,
+		Sequence:        peer.sequence,
+		Acknowledgement: ack,
+		OffsetFlags:     flags | TCPOffset,
+		Window:          peer.window,
+	}
+	peer.sequence += uint32(len(data))
+	if flags&TCPHeaderFlagFIN != 0 {
+		peer.sequence++
+	}
+
+	peer.channel.receivePacket(header, data)
+}
+
+func payload(packets []*testPacket) []byte {
+	var data []byte
+	for _, packet := range packets {
+		data = append(data, packet.data...)
+	}
+	return data
+}
+
+func TestTCPChannelSegmentsAndCoalesces(t *testing.T) {
+	sender := newTestSender()
+	peer := connect(t, sender, &testHandler{})
+
+	peer.channel.send(bytes.Repeat([]byte{'a'}, 2500))
+	packets := sender.take()
+	if len(packets) != 2 || len(packets[0].data) != 1000 || len(packets[1].data) != 1000 {
+		t.Fatalf("expected two full segments with the short tail held back, got %d packets", len(packets))
+	}
+	if packets[1].header.Sequence != packets[0].header.Sequence+1000 {
+		t.Fatalf("segments not contiguous: %d then %d", packets[0].header.Sequence, packets[1].header.Sequence)
+	}
+
+	// The tail joins later writes until the outstanding data is acknowledged
+	peer.channel.send([]byte("bc"))
+	if packets = sender.take(); len(packets) != 0 {
+		t.Fatalf("short write sent with data in flight")
+	}
+
+	peer.send(TCPHeaderFlagACK, nil)
+	packets = sender.take()
+	if len(packets) != 1 || len(packets[0].data) != 502 {
+		t.Fatalf("expected the coalesced tail once acknowledged, got %d packets", len(packets))
+	}
+}
+
+func TestTCPChannelNoDelay(t *testing.T) {
+	sender := newTestSender()
+	peer := connect(t, sender, &testHandler{})
+
+	peer.channel.send([]byte("first"))
+	peer.channel.send([]byte("second"))
+	if data := payload(sender.take()); string(data) != "first" {
+		t.Fatalf("expected only the first write before an acknowledgement, got %q", data)
+	}
+
+	// Turning no-delay on releases the held back write
+	peer.channel.setNoDelay(true)
+	if data := payload(sender.take()); string(data) != "second" {
+		t.Fatalf("expected the held back write, got %q", data)
+	}
+
+	peer.channel.send([]byte("third"))
+	if data := payload(sender.take()); string(data) != "third" {
+		t.Fatalf("expected the write to go out with data in flight, got %q", data)
+	}
+}
+
+func TestTCPChannelWaitsForPeerWindow(t *testing.T) {
+	sender := newTestSender()
+	peer := connect(t, sender, &testHandler{})
+	peer.channel.setNoDelay(true)
+
+	// The device only has room for 2048 bytes
+	peer.window = 2048 >> TCPWindowShift
+	peer.send(TCPHeaderFlagACK, nil)
+
+	data := bytes.Repeat([]byte("window"), 1000)
+	peer.channel.send(data)
+	sent := payload(sender.take())
+	if len(sent) != 2048 {
+		t.Fatalf("expected the peer window to be filled, got %d bytes", len(sent))
+	}
+
+	// Acknowledging without opening the window lets the same amount through
+	peer.send(TCPHeaderFlagACK, nil)
+	more := payload(sender.take())
+	if len(more) != 2048 {
+		t.Fatalf("expected another window after the acknowledgement, got %d bytes", len(more))
+	}
+	sent = append(sent, more...)
+
+	peer.window = 0xFFFF
+	peer.send(TCPHeaderFlagACK, nil)
+	sent = append(sent, payload(sender.take())...)
+	if !bytes.Equal(sent, data) {
+		t.Fatalf("expected the queued data once the window opened, got %d of %d bytes", len(sent), len(data))
+	}
+}
+
+func TestTCPChannelHalfClose(t *testing.T) {
+	sender := newTestSender()
+	handler := &testHandler{}
+	peer := connect(t, sender, handler)
+	peer.channel.setNoDelay(true)
+
+	peer.send(TCPHeaderFlagFIN|TCPHeaderFlagACK, nil)
+	if state := peer.channel.currentState(); state != TCPStateCloseWait {
+		t.Fatalf("expected CLOSE_WAIT after the device's FIN, got state %d", state)
+	}
+	for _, packet := range sender.take() {
+		if packet.header.hasFlag(TCPHeaderFlagFIN) {
+			t.Fatalf("FIN sent before the local side closed")
+		}
+	}
+
+	// Our side is still open for sending
+	peer.channel.send([]byte("reply"))
+	packets := sender.take()
+	if data := payload(packets); string(data) != "reply" {
+		t.Fatalf("expected the reply to be sent in CLOSE_WAIT, got %q", data)
+	}
+
+	peer.channel.Close()
+	packets = sender.take()
+	if len(packets) != 1 || !packets[0].header.hasFlag(TCPHeaderFlagFIN) {
+		t.Fatalf("expected FIN on Close, got %d packets", len(packets))
+	}
+	if state := peer.channel.currentState(); state != TCPStateClosing {
+		t.Fatalf("expected closing until the FIN is acknowledged, got state %d", state)
+	}
+
+	peer.send(TCPHeaderFlagACK, nil)
+	if state := peer.channel.currentState(); state != TCPStateClosed {
+		t.Fatalf("expected closed once the FIN was acknowledged, got state %d", state)
+	}
+	if len(sender.closed) != 1 {
+		t.Fatalf("expected the channel to be released once, got %d", len(sender.closed))
+	}
+
+	expected := []int{TCPStateConnected, TCPStateCloseWait, TCPStateClosing, TCPStateClosed}
+	if len(handler.states) != len(expected) {
+		t.Fatalf("expected states %v, got %v", expected, handler.states)
+	}
+	for i := range expected {
+		if handler.states[i] != expected[i] {
+			t.Fatalf("expected states %v, got %v", expected, handler.states)
+		}
+	}
+}
+
+func TestTCPChannelKeepAlive(t *testing.T) {
+	sender := newTestSender()
+	sender.config.keepAliveInterval = 10 * time.Second
+	handler := &testHandler{}
+	peer := connect(t, sender, handler)
+
+	sender.clock.Advance(10 * time.Second)
+	packets := sender.take()
+	if len(packets) != 1 || len(packets[0].data) != 0 || packets[0].header.Sequence != peer.channel.txSequence-1 {
+		t.Fatalf("expected an empty probe one sequence number back, got %d packets", len(packets))
+	}
+
+	// An answer resets the probe count
+	peer.send(TCPHeaderFlagACK, nil)
+	sender.clock.Advance(10 * time.Second)
+	sender.clock.Advance(10 * time.Second)
+	sender.clock.Advance(10 * time.Second)
+	if state := peer.channel.currentState(); state != TCPStateConnected {
+		t.Fatalf("channel aborted before running out of probes, state %d", state)
+	}
+	if packets = sender.take(); len(packets) != TCPKeepAliveProbes {
+		t.Fatalf("expected %d probes, got %d packets", TCPKeepAliveProbes, len(packets))
+	}
+
+	sender.clock.Advance(10 * time.Second)
+	packets = sender.take()
+	if len(packets) != 1 || !packets[0].header.hasFlag(TCPHeaderFlagRST) {
+		t.Fatalf("expected RST after unanswered probes, got %d packets", len(packets))
+	}
+	if state := handler.lastState(); state != TCPStateClosed {
+		t.Fatalf("expected the handler to see the channel closed, got state %d", state)
+	}
+	if sender.clock.pending() != 0 {
+		t.Fatalf("%d timers left running after the abort", sender.clock.pending())
+	}
+}
+
+func TestTCPChannelIdleReaped(t *testing.T) {
+	sender := newTestSender()
+	sender.config.idleTimeout = 30 * time.Second
+	handler := &testHandler{}
+	peer := connect(t, sender, handler)
+	peer.channel.setNoDelay(true)
+
+	// Traffic pushes the deadline out
+	sender.clock.Advance(20 * time.Second)
+	peer.channel.send([]byte("ping"))
+	sender.clock.Advance(20 * time.Second)
+	if state := peer.channel.currentState(); state != TCPStateConnected {
+		t.Fatalf("active channel reaped, state %d", state)
+	}
+	sender.take()
+
+	sender.clock.Advance(10 * time.Second)
+	packets := sender.take()
+	if len(packets) != 1 || !packets[0].header.hasFlag(TCPHeaderFlagRST) {
+		t.Fatalf("expected RST for the idle channel, got %d packets", len(packets))
+	}
+	if state := handler.lastState(); state != TCPStateClosed {
+		t.Fatalf("expected the handler to see the channel closed, got state %d", state)
+	}
+	if len(sender.closed) != 1 {
+		t.Fatalf("expected the reaped channel to be released, got %d", len(sender.closed))
+	}
+}
@@ -0,0 +1,840 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.25.0-devel
+// 	protoc        v3.13.0
+// source: usb.proto
+
+package main
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type USBDirection int32
+
+const (
+	USBDirection_IN  USBDirection = 0
+	USBDirection_OUT USBDirection = 1
+)
+
+// Enum value maps for USBDirection.
+var (
+	USBDirection_name = map[int32]string{
+		0: "IN",
+		1: "OUT",
+	}
+	USBDirection_value = map[string]int32{
+		"IN":  0,
+		"OUT": 1,
+	}
+)
+
+func (x USBDirection) Enum() *USBDirection {
+	p := new(USBDirection)
+	*p = x
+	return p
+}
+
+func (x USBDirection) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (USBDirection) Descriptor() protoreflect.EnumDescriptor {
+	return file_usb_proto_enumTypes[0].Descriptor()
+}
+
+func (USBDirection) Type() protoreflect.EnumType {
+	return &file_usb_proto_enumTypes[0]
+}
+
+func (x USBDirection) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use USBDirection.Descriptor instead.
+func (USBDirection) EnumDescriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{0}
+}
+
+type USBEndpointType int32
+
+const (
+	USBEndpointType_BULK        USBEndpointType = 0
+	USBEndpointType_INTERRUPT   USBEndpointType = 1
+	USBEndpointType_ISOCHRONOUS USBEndpointType = 2
+)
+
+// Enum value maps for USBEndpointType.
+var (
+	USBEndpointType_name = map[int32]string{
+		0: "BULK",
+		1: "INTERRUPT",
+		2: "ISOCHRONOUS",
+	}
+	USBEndpointType_value = map[string]int32{
+		"BULK":        0,
+		"INTERRUPT":   1,
+		"ISOCHRONOUS": 2,
+	}
+)
+
+func (x USBEndpointType) Enum() *USBEndpointType {
+	p := new(USBEndpointType)
+	*p = x
+	return p
+}
+
+func (x USBEndpointType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (USBEndpointType) Descriptor() protoreflect.EnumDescriptor {
+	return file_usb_proto_enumTypes[1].Descriptor()
+}
+
+func (USBEndpointType) Type() protoreflect.EnumType {
+	return &file_usb_proto_enumTypes[1]
+}
+
+func (x USBEndpointType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use USBEndpointType.Descriptor instead.
+func (USBEndpointType) EnumDescriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{1}
+}
+
+type USBEventType int32
+
+const (
+	USBEventType_CONNECTED    USBEventType = 0
+	USBEventType_DISCONNECTED USBEventType = 1
+	USBEventType_ERROR        USBEventType = 2
+)
+
+// Enum value maps for USBEventType.
+var (
+	USBEventType_name = map[int32]string{
+		0: "CONNECTED",
+		1: "DISCONNECTED",
+		2: "ERROR",
+	}
+	USBEventType_value = map[string]int32{
+		"CONNECTED":    0,
+		"DISCONNECTED": 1,
+		"ERROR":        2,
+	}
+)
+
+func (x USBEventType) Enum() *USBEventType {
+	p := new(USBEventType)
+	*p = x
+	return p
+}
+
+func (x USBEventType) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (USBEventType) Descriptor() protoreflect.EnumDescriptor {
+	return file_usb_proto_enumTypes[2].Descriptor()
+}
+
+func (USBEventType) Type() protoreflect.EnumType {
+	return &file_usb_proto_enumTypes[2]
+}
+
+func (x USBEventType) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use USBEventType.Descriptor instead.
+func (USBEventType) EnumDescriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{2}
+}
+
+type USBDevice struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UsbVersionMajor       uint32              `protobuf:"varint,1,opt,name=usbVersionMajor,proto3" json:"usbVersionMajor,omitempty"`
+	UsbVersionMinor       uint32              `protobuf:"varint,2,opt,name=usbVersionMinor,proto3" json:"usbVersionMinor,omitempty"`
+	UsbVersionSubminor    uint32              `protobuf:"varint,3,opt,name=usbVersionSubminor,proto3" json:"usbVersionSubminor,omitempty"`
+	DeviceClass           uint32              `protobuf:"varint,4,opt,name=deviceClass,proto3" json:"deviceClass,omitempty"`
+	DeviceSubclass        uint32              `protobuf:"varint,5,opt,name=deviceSubclass,proto3" json:"deviceSubclass,omitempty"`
+	DeviceProtocol        uint32              `protobuf:"varint,6,opt,name=deviceProtocol,proto3" json:"deviceProtocol,omitempty"`
+	VendorId              uint32              `protobuf:"varint,7,opt,name=vendorId,proto3" json:"vendorId,omitempty"`
+	ProductId             uint32              `protobuf:"varint,8,opt,name=productId,proto3" json:"productId,omitempty"`
+	DeviceVersionMajor    uint32              `protobuf:"varint,9,opt,name=deviceVersionMajor,proto3" json:"deviceVersionMajor,omitempty"`
+	DeviceVersionMinor    uint32              `protobuf:"varint,10,opt,name=deviceVersionMinor,proto3" json:"deviceVersionMinor,omitempty"`
+	DeviceVersionSubminor uint32              `protobuf:"varint,11,opt,name=deviceVersionSubminor,proto3" json:"deviceVersionSubminor,omitempty"`
+	ManufacturerName      string              `protobuf:"bytes,12,opt,name=manufacturerName,proto3" json:"manufacturerName,omitempty"`
+	ProductName           string              `protobuf:"bytes,13,opt,name=productName,proto3" json:"productName,omitempty"`
+	SerialNumber          string              `protobuf:"bytes,14,opt,name=serialNumber,proto3" json:"serialNumber,omitempty"`
+	SelectedConfiguration uint32              `protobuf:"varint,15,opt,name=selectedConfiguration,proto3" json:"selectedConfiguration,omitempty"`
+	Configurations        []*USBConfiguration `protobuf:"bytes,16,rep,name=configurations,proto3" json:"configurations,omitempty"`
+}
+
+func (x *USBDevice) Reset() {
+	*x = USBDevice{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_usb_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *USBDevice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*USBDevice) ProtoMessage() {}
+
+func (x *USBDevice) ProtoReflect() protoreflect.Message {
+	mi := &file_usb_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use USBDevice.ProtoReflect.Descriptor instead.
+func (*USBDevice) Descriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *USBDevice) GetUsbVersionMajor() uint32 {
+	if x != nil {
+		return x.UsbVersionMajor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetUsbVersionMinor() uint32 {
+	if x != nil {
+		return x.UsbVersionMinor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetUsbVersionSubminor() uint32 {
+	if x != nil {
+		return x.UsbVersionSubminor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceClass() uint32 {
+	if x != nil {
+		return x.DeviceClass
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceSubclass() uint32 {
+	if x != nil {
+		return x.DeviceSubclass
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceProtocol() uint32 {
+	if x != nil {
+		return x.DeviceProtocol
+	}
+	return 0
+}
+
+func (x *USBDevice) GetVendorId() uint32 {
+	if x != nil {
+		return x.VendorId
+	}
+	return 0
+}
+
+func (x *USBDevice) GetProductId() uint32 {
+	if x != nil {
+		return x.ProductId
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceVersionMajor() uint32 {
+	if x != nil {
+		return x.DeviceVersionMajor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceVersionMinor() uint32 {
+	if x != nil {
+		return x.DeviceVersionMinor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetDeviceVersionSubminor() uint32 {
+	if x != nil {
+		return x.DeviceVersionSubminor
+	}
+	return 0
+}
+
+func (x *USBDevice) GetManufacturerName() string {
+	if x != nil {
+		return x.ManufacturerName
+	}
+	return ""
+}
+
+func (x *USBDevice) GetProductName() string {
+	if x != nil {
+		return x.ProductName
+	}
+	return ""
+}
+
+func (x *USBDevice) GetSerialNumber() string {
+	if x != nil {
+		return x.SerialNumber
+	}
+	return ""
+}
+
+func (x *USBDevice) GetSelectedConfiguration() uint32 {
+	if x != nil {
+		return x.SelectedConfiguration
+	}
+	return 0
+}
+
+func (x *USBDevice) GetConfigurations() []*USBConfiguration {
+	if x != nil {
+		return x.Configurations
+	}
+	return nil
+}
+
+type USBConfiguration struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ConfigurationValue uint32          `protobuf:"varint,1,opt,name=configurationValue,proto3" json:"configurationValue,omitempty"`
+	ConfigurationName  string          `protobuf:"bytes,2,opt,name=configurationName,proto3" json:"configurationName,omitempty"`
+	Interfaces         []*USBInterface `protobuf:"bytes,3,rep,name=interfaces,proto3" json:"interfaces,omitempty"`
+}
+
+func (x *USBConfiguration) Reset() {
+	*x = USBConfiguration{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_usb_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *USBConfiguration) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*USBConfiguration) ProtoMessage() {}
+
+func (x *USBConfiguration) ProtoReflect() protoreflect.Message {
+	mi := &file_usb_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use USBConfiguration.ProtoReflect.Descriptor instead.
+func (*USBConfiguration) Descriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *USBConfiguration) GetConfigurationValue() uint32 {
+	if x != nil {
+		return x.ConfigurationValue
+	}
+	return 0
+}
+
+func (x *USBConfiguration) GetConfigurationName() string {
+	if x != nil {
+		return x.ConfigurationName
+	}
+	return ""
+}
+
+func (x *USBConfiguration) GetInterfaces() []*USBInterface {
+	if x != nil {
+		return x.Interfaces
+	}
+	return nil
+}
+
+type USBInterface struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	InterfaceNumber uint32                   `protobuf:"varint,1,opt,name=interfaceNumber,proto3" json:"interfaceNumber,omitempty"`
+	Alternates      []*USBAlternateInterface `protobuf:"bytes,2,rep,name=alternates,proto3" json:"alternates,omitempty"`
+}
+
+func (x *USBInterface) Reset() {
+	*x = USBInterface{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_usb_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *USBInterface) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*USBInterface) ProtoMessage() {}
+
+func (x *USBInterface) ProtoReflect() protoreflect.Message {
+	mi := &file_usb_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use USBInterface.ProtoReflect.Descriptor instead.
+func (*USBInterface) Descriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *USBInterface) GetInterfaceNumber() uint32 {
+	if x != nil {
+		return x.InterfaceNumber
+	}
+	return 0
+}
+
+func (x *USBInterface) GetAlternates() []*USBAlternateInterface {
+	if x != nil {
+		return x.Alternates
+	}
+	return nil
+}
+
+type USBAlternateInterface struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	AlternateSetting  uint32         `protobuf:"varint,1,opt,name=alternateSetting,proto3" json:"alternateSetting,omitempty"`
+	InterfaceClass    uint32         `protobuf:"varint,2,opt,name=interfaceClass,proto3" json:"interfaceClass,omitempty"`
+	InterfaceSubclass uint32         `protobuf:"varint,3,opt,name=interfaceSubclass,proto3" json:"interfaceSubclass,omitempty"`
+	InterfaceProtocol uint32         `protobuf:"varint,4,opt,name=interfaceProtocol,proto3" json:"interfaceProtocol,omitempty"`
+	InterfaceName     string         `protobuf:"bytes,5,opt,name=interfaceName,proto3" json:"interfaceName,omitempty"`
+	Endpoints         []*USBEndpoint `protobuf:"bytes,6,rep,name=endpoints,proto3" json:"endpoints,omitempty"`
+}
+
+func (x *USBAlternateInterface) Reset() {
+	*x = USBAlternateInterface{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_usb_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *USBAlternateInterface) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*USBAlternateInterface) ProtoMessage() {}
+
+func (x *USBAlternateInterface) ProtoReflect() protoreflect.Message {
+	mi := &file_usb_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use USBAlternateInterface.ProtoReflect.Descriptor instead.
+func (*USBAlternateInterface) Descriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *USBAlternateInterface) GetAlternateSetting() uint32 {
+	if x != nil {
+		return x.AlternateSetting
+	}
+	return 0
+}
+
+func (x *USBAlternateInterface) GetInterfaceClass() uint32 {
+	if x != nil {
+		return x.InterfaceClass
+	}
+	return 0
+}
+
+func (x *USBAlternateInterface) GetInterfaceSubclass() uint32 {
+	if x != nil {
+		return x.InterfaceSubclass
+	}
+	return 0
+}
+
+func (x *USBAlternateInterface) GetInterfaceProtocol() uint32 {
+	if x != nil {
+		return x.InterfaceProtocol
+	}
+	return 0
+}
+
+func (x *USBAlternateInterface) GetInterfaceName() string {
+	if x != nil {
+		return x.InterfaceName
+	}
+	return ""
+}
+
+func (x *USBAlternateInterface) GetEndpoints() []*USBEndpoint {
+	if x != nil {
+		return x.Endpoints
+	}
+	return nil
+}
+
+type USBEndpoint struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	EndpointNumber uint32          `protobuf:"varint,1,opt,name=endpointNumber,proto3" json:"endpointNumber,omitempty"`
+	Direction      USBDirection    `protobuf:"varint,2,opt,name=direction,proto3,enum=USBDirection" json:"direction,omitempty"`
+	Type           USBEndpointType `protobuf:"varint,3,opt,name=type,proto3,enum=USBEndpointType" json:"type,omitempty"`
+	PacketSize     uint64          `protobuf:"varint,4,opt,name=packetSize,proto3" json:"packetSize,omitempty"`
+}
+
+func (x *USBEndpoint) Reset() {
+	*x = USBEndpoint{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_usb_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *USBEndpoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*USBEndpoint) ProtoMessage() {}
+
+func (x *USBEndpoint) ProtoReflect() protoreflect.Message {
+	mi := &file_usb_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use USBEndpoint.ProtoReflect.Descriptor instead.
+func (*USBEndpoint) Descriptor() ([]byte, []int) {
+	return file_usb_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *USBEndpoint) GetEndpointNumber() uint32 {
+	if x != nil {
+		return x.EndpointNumber
+	}
+	return 0
+}
+
+func (x *USBEndpoint) GetDirection() USBDirection {
+	if x != nil {
+		return x.Direction
+	}
+	return USBDirection_IN
+}
+
+func (x *USBEndpoint) GetType() USBEndpointType {
+	if x != nil {
+		return x.Type
+	}
+	return USBEndpointType_BULK
+}
+
+func (x *USBEndpoint) GetPacketSize() uint64 {
+	if x != nil {
+		return x.PacketSize
+	}
+	return 0
+}
+
+var File_usb_proto protoreflect.FileDescriptor
+
+var file_usb_proto_rawDesc = []byte{
+	0x0a, 0x09, 0x75, 0x73, 0x62, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xb4, 0x05, 0x0a, 0x09,
+	0x55, 0x53, 0x42, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x75, 0x73, 0x62,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x61, 0x6a, 0x6f, 0x72, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0f, 0x75, 0x73, 0x62, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x61,
+	0x6a, 0x6f, 0x72, 0x12, 0x28, 0x0a, 0x0f, 0x75, 0x73, 0x62, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x4d, 0x69, 0x6e, 0x6f, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0f, 0x75, 0x73,
+	0x62, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x69, 0x6e, 0x6f, 0x72, 0x12, 0x2e, 0x0a,
+	0x12, 0x75, 0x73, 0x62, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x6d, 0x69,
+	0x6e, 0x6f, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x75, 0x73, 0x62, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x6d, 0x69, 0x6e, 0x6f, 0x72, 0x12, 0x20, 0x0a,
+	0x0b, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x0d, 0x52, 0x0b, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x12,
+	0x26, 0x0a, 0x0e, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x53, 0x75, 0x62, 0x63, 0x6c, 0x61, 0x73,
+	0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x53,
+	0x75, 0x62, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x12, 0x26, 0x0a, 0x0e, 0x64, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x06, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x0e, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x12,
+	0x1a, 0x0a, 0x08, 0x76, 0x65, 0x6e, 0x64, 0x6f, 0x72, 0x49, 0x64, 0x18, 0x07, 0x20, 0x01, 0x28,
+	0x0d, 0x52, 0x08, 0x76, 0x65, 0x6e, 0x64, 0x6f, 0x72, 0x49, 0x64, 0x12, 0x1c, 0x0a, 0x09, 0x70,
+	0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x49, 0x64, 0x18, 0x08, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09,
+	0x70, 0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x49, 0x64, 0x12, 0x2e, 0x0a, 0x12, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x61, 0x6a, 0x6f, 0x72, 0x18,
+	0x09, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x56, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x61, 0x6a, 0x6f, 0x72, 0x12, 0x2e, 0x0a, 0x12, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x69, 0x6e, 0x6f, 0x72, 0x18,
+	0x0a, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x12, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x56, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x4d, 0x69, 0x6e, 0x6f, 0x72, 0x12, 0x34, 0x0a, 0x15, 0x64, 0x65, 0x76,
+	0x69, 0x63, 0x65, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x6d, 0x69, 0x6e,
+	0x6f, 0x72, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x15, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x75, 0x62, 0x6d, 0x69, 0x6e, 0x6f, 0x72, 0x12,
+	0x2a, 0x0a, 0x10, 0x6d, 0x61, 0x6e, 0x75, 0x66, 0x61, 0x63, 0x74, 0x75, 0x72, 0x65, 0x72, 0x4e,
+	0x61, 0x6d, 0x65, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x09, 0x52, 0x10, 0x6d, 0x61, 0x6e, 0x75, 0x66,
+	0x61, 0x63, 0x74, 0x75, 0x72, 0x65, 0x72, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x20, 0x0a, 0x0b, 0x70,
+	0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x0d, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0b, 0x70, 0x72, 0x6f, 0x64, 0x75, 0x63, 0x74, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x22, 0x0a,
+	0x0c, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x0e, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0c, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x4e, 0x75, 0x6d, 0x62, 0x65,
+	0x72, 0x12, 0x34, 0x0a, 0x15, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x65, 0x64, 0x43, 0x6f, 0x6e,
+	0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x15, 0x73, 0x65, 0x6c, 0x65, 0x63, 0x74, 0x65, 0x64, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x39, 0x0a, 0x0e, 0x63, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x10, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x11, 0x2e, 0x55, 0x53, 0x42, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x52, 0x0e, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x73, 0x22, 0x9f, 0x01, 0x0a, 0x10, 0x55, 0x53, 0x42, 0x43, 0x6f, 0x6e, 0x66, 0x69, 0x67,
+	0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x2e, 0x0a, 0x12, 0x63, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0d, 0x52, 0x12, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69,
+	0x6f, 0x6e, 0x56, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x2c, 0x0a, 0x11, 0x63, 0x6f, 0x6e, 0x66, 0x69,
+	0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x11, 0x63, 0x6f, 0x6e, 0x66, 0x69, 0x67, 0x75, 0x72, 0x61, 0x74, 0x69, 0x6f,
+	0x6e, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x2d, 0x0a, 0x0a, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61,
+	0x63, 0x65, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0d, 0x2e, 0x55, 0x53, 0x42, 0x49,
+	0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x52, 0x0a, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66,
+	0x61, 0x63, 0x65, 0x73, 0x22, 0x70, 0x0a, 0x0c, 0x55, 0x53, 0x42, 0x49, 0x6e, 0x74, 0x65, 0x72,
+	0x66, 0x61, 0x63, 0x65, 0x12, 0x28, 0x0a, 0x0f, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63,
+	0x65, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0f, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x36,
+	0x0a, 0x0a, 0x61, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x65, 0x73, 0x18, 0x02, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x16, 0x2e, 0x55, 0x53, 0x42, 0x41, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74,
+	0x65, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x52, 0x0a, 0x61, 0x6c, 0x74, 0x65,
+	0x72, 0x6e, 0x61, 0x74, 0x65, 0x73, 0x22, 0x99, 0x02, 0x0a, 0x15, 0x55, 0x53, 0x42, 0x41, 0x6c,
+	0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x65, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65,
+	0x12, 0x2a, 0x0a, 0x10, 0x61, 0x6c, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x74, 0x65, 0x53, 0x65, 0x74,
+	0x74, 0x69, 0x6e, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x10, 0x61, 0x6c, 0x74, 0x65,
+	0x72, 0x6e, 0x61, 0x74, 0x65, 0x53, 0x65, 0x74, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x26, 0x0a, 0x0e,
+	0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x43, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x43,
+	0x6c, 0x61, 0x73, 0x73, 0x12, 0x2c, 0x0a, 0x11, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63,
+	0x65, 0x53, 0x75, 0x62, 0x63, 0x6c, 0x61, 0x73, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d, 0x52,
+	0x11, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x53, 0x75, 0x62, 0x63, 0x6c, 0x61,
+	0x73, 0x73, 0x12, 0x2c, 0x0a, 0x11, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x50,
+	0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x11, 0x69,
+	0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c,
+	0x12, 0x24, 0x0a, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61, 0x63, 0x65, 0x4e, 0x61, 0x6d,
+	0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x69, 0x6e, 0x74, 0x65, 0x72, 0x66, 0x61,
+	0x63, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x2a, 0x0a, 0x09, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69,
+	0x6e, 0x74, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x55, 0x53, 0x42, 0x45,
+	0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x52, 0x09, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e,
+	0x74, 0x73, 0x22, 0xa8, 0x01, 0x0a, 0x0b, 0x55, 0x53, 0x42, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69,
+	0x6e, 0x74, 0x12, 0x26, 0x0a, 0x0e, 0x65, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x4e, 0x75,
+	0x6d, 0x62, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0e, 0x65, 0x6e, 0x64, 0x70,
+	0x6f, 0x69, 0x6e, 0x74, 0x4e, 0x75, 0x6d, 0x62, 0x65, 0x72, 0x12, 0x2b, 0x0a, 0x09, 0x64, 0x69,
+	0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x0d, 0x2e,
+	0x55, 0x53, 0x42, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x09, 0x64, 0x69,
+	0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x04, 0x74, 0x79, 0x70, 0x65, 0x18,
+	0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x10, 0x2e, 0x55, 0x53, 0x42, 0x45, 0x6e, 0x64, 0x70, 0x6f,
+	0x69, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x52, 0x04, 0x74, 0x79, 0x70, 0x65, 0x12, 0x1e, 0x0a,
+	0x0a, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0a, 0x70, 0x61, 0x63, 0x6b, 0x65, 0x74, 0x53, 0x69, 0x7a, 0x65, 0x2a, 0x1f, 0x0a,
+	0x0c, 0x55, 0x53, 0x42, 0x44, 0x69, 0x72, 0x65, 0x63, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x06, 0x0a,
+	0x02, 0x49, 0x4e, 0x10, 0x00, 0x12, 0x07, 0x0a, 0x03, 0x4f, 0x55, 0x54, 0x10, 0x01, 0x2a, 0x3b,
+	0x0a, 0x0f, 0x55, 0x53, 0x42, 0x45, 0x6e, 0x64, 0x70, 0x6f, 0x69, 0x6e, 0x74, 0x54, 0x79, 0x70,
+	0x65, 0x12, 0x08, 0x0a, 0x04, 0x42, 0x55, 0x4c, 0x4b, 0x10, 0x00, 0x12, 0x0d, 0x0a, 0x09, 0x49,
+	0x4e, 0x54, 0x45, 0x52, 0x52, 0x55, 0x50, 0x54, 0x10, 0x01, 0x12, 0x0f, 0x0a, 0x0b, 0x49, 0x53,
+	0x4f, 0x43, 0x48, 0x52, 0x4f, 0x4e, 0x4f, 0x55, 0x53, 0x10, 0x02, 0x2a, 0x3a, 0x0a, 0x0c, 0x55,
+	0x53, 0x42, 0x45, 0x76, 0x65, 0x6e, 0x74, 0x54, 0x79, 0x70, 0x65, 0x12, 0x0d, 0x0a, 0x09, 0x43,
+	0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x45, 0x44, 0x10, 0x00, 0x12, 0x10, 0x0a, 0x0c, 0x44, 0x49,
+	0x53, 0x43, 0x4f, 0x4e, 0x4e, 0x45, 0x43, 0x54, 0x45, 0x44, 0x10, 0x01, 0x12, 0x09, 0x0a, 0x05,
+	0x45, 0x52, 0x52, 0x4f, 0x52, 0x10, 0x02, 0x42, 0x08, 0x5a, 0x06, 0x2e, 0x3b, 0x6d, 0x61, 0x69,
+	0x6e, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_usb_proto_rawDescOnce sync.Once
+	file_usb_proto_rawDescData = file_usb_proto_rawDesc
+)
+
+func file_usb_proto_rawDescGZIP() []byte {
+	file_usb_proto_rawDescOnce.Do(func() {
+		file_usb_proto_rawDescData = protoimpl.X.CompressGZIP(file_usb_proto_rawDescData)
+	})
+	return file_usb_proto_rawDescData
+}
+
+var file_usb_proto_enumTypes = make([]protoimpl.EnumInfo, 3)
+var file_usb_proto_msgTypes = make([]protoimpl.MessageInfo, 5)
+var file_usb_proto_goTypes = []interface{}{
+	(USBDirection)(0),             // 0: USBDirection
+	(USBEndpointType)(0),          // 1: USBEndpointType
+	(USBEventType)(0),             // 2: USBEventType
+	(*USBDevice)(nil),             // 3: USBDevice
+	(*USBConfiguration)(nil),      // 4: USBConfiguration
+	(*USBInterface)(nil),          // 5: USBInterface
+	(*USBAlternateInterface)(nil), // 6: USBAlternateInterface
+	(*USBEndpoint)(nil),           // 7: USBEndpoint
+}
+var file_usb_proto_depIdxs = []int32{
+	4, // 0: USBDevice.configurations:type_name -> USBConfiguration
+	5, // 1: USBConfiguration.interfaces:type_name -> USBInterface
+	6, // 2: USBInterface.alternates:type_name -> USBAlternateInterface
+	7, // 3: USBAlternateInterface.endpoints:type_name -> USBEndpoint
+	0, // 4: USBEndpoint.direction:type_name -> USBDirection
+	1, // 5: USBEndpoint.type:type_name -> USBEndpointType
+	6, // [6:6] is the sub-list for method output_type
+	6, // [6:6] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_usb_proto_init() }
+func file_usb_proto_init() {
+	if File_usb_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_usb_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*USBDevice); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_usb_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*USBConfiguration); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_usb_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*USBInterface); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_usb_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*USBAlternateInterface); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_usb_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*USBEndpoint); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_usb_proto_rawDesc,
+			NumEnums:      3,
+			NumMessages:   5,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_usb_proto_goTypes,
+		DependencyIndexes: file_usb_proto_depIdxs,
+		EnumInfos:         file_usb_proto_enumTypes,
+		MessageInfos:      file_usb_proto_msgTypes,
+	}.Build()
+	File_usb_proto = out.File
+	file_usb_proto_rawDesc = nil
+	file_usb_proto_goTypes = nil
+	file_usb_proto_depIdxs = nil
+}